@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/metrics"
+)
+
+const drainReport = 2 * time.Second
+
+// drain waits for the proxied connections to finish on their own, logging
+// progress, until none are left, timeout passes or another signal arrives;
+// whatever is still open then is force-closed.
+func drain(timeout time.Duration, sig <-chan os.Signal) {
+	start := time.Now()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	report := time.NewTicker(drainReport)
+	defer report.Stop()
+	poll := time.NewTicker(100 * time.Millisecond)
+	defer poll.Stop()
+
+	zap.L().Info("drain_start", zap.Int64("open", metrics.OpenConns()), zap.Duration("timeout", timeout))
+	for {
+		if metrics.OpenConns() == 0 {
+			zap.L().Info("drain_done", zap.Duration("took", time.Since(start)))
+			return
+		}
+		select {
+		case <-poll.C:
+		case <-report.C:
+			zap.L().Info("drain_progress",
+				zap.Int64("open", metrics.OpenConns()),
+				zap.Duration("left", timeout-time.Since(start)),
+			)
+		case <-deadline.C:
+			zap.L().Warn("drain_timeout", zap.Int("force_closed", metrics.CloseAll()))
+			return
+		case <-sig:
+			zap.L().Warn("drain_aborted", zap.Int("force_closed", metrics.CloseAll()))
+			return
+		}
+	}
+}
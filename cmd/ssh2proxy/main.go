@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net"
 	"net/http"
 	"os"
@@ -13,6 +14,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/config"
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/handoff"
 	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/logger"
 	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/metrics"
 	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/proxy"
@@ -21,56 +23,113 @@ import (
 )
 
 const (
-	keepAliveInterval     = 1 * time.Second
-	sleepToReconnect      = 5 * time.Second
-	timeCloser            = 2 * time.Second
-	timeOutIdleConnection = 30 * time.Second
+	sleepToReconnect = 5 * time.Second
+	timeCloser       = 2 * time.Second
 )
 
 func main() {
 	cfg := config.Load()
 	logger.Init(cfg.Debug)
 
-	bootDNS := proxy.NewDNSResolver(cfg.DNSServers, cfg.DNSv6, nil)
+	hosts, err := proxy.LoadHosts(cfg.DNSHosts, cfg.DNSHostsFile)
+	if err != nil {
+		zap.L().Fatal("DNS hosts", zap.Error(err))
+	}
+
+	upDial, err := sshclient.UpstreamProxy(cfg.UpstreamProxy, cfg.UpstreamProxyEnv)
+	if err != nil {
+		zap.L().Fatal("upstream proxy", zap.Error(err))
+	}
+
+	bootDNS := proxy.NewDNSResolver(cfg.DNSServers, cfg.DNSv6, nil).
+		WithSplit(nil, hosts, false).
+		WithBootDial(upDial)
 
 	var (
 		sshCl *sshclient.Reconnector
 		dial  sshclient.DialFunc
-		err   error
 	)
 
 	for {
-		_, ip, er := bootDNS.ResolveBoot(context.Background(), cfg.Server)
-		if er != nil {
-			zap.L().Info("bootstrap DNS failed", zap.Error(er))
-			time.Sleep(sleepToReconnect)
-			continue
-		}
-
-		sshCl, dial, er = sshclient.New(cfg.Login, cfg.Password, ip.String(), cfg.Port, cfg.KeyPath)
+		var er error
+		sshCl, dial, er = sshclient.New(cfg, bootDNS.LookupBoot)
 		if er == nil {
 			break
 		}
+		if errors.Is(er, sshclient.ErrConfig) {
+			zap.L().Fatal("SSH config", zap.Error(er))
+		}
 		zap.L().Info("SSH connect failed", zap.Error(er), zap.String("sleep", "10s"))
 		time.Sleep(sleepToReconnect)
 	}
-	defer sshCl.Close()
 
-	sshclient.StartKeepAlive(cfg, sshCl, keepAliveInterval)
+	sshCl.OnStateChange(func(ev sshclient.StateEvent) {
+		if ev.To == sshclient.StateDown {
+			zap.L().Error("upstream down", zap.String("from", ev.From.String()), zap.Error(ev.Err))
+		}
+	})
+
+	sshclient.StartKeepAlive(cfg, sshCl)
 	sshclient.StartChannelMonitor(sshCl)
 
+	var fakePool *proxy.FakeIPPool
+	var fakeDNS *proxy.FakeDNSServer
+	if cfg.FakeDNSL != "" {
+		fakePool, err = proxy.NewFakeIPPool(cfg.FakeIPRange, cfg.FakeIPTTL)
+		if err != nil {
+			zap.L().Fatal("fake-ip pool", zap.Error(err))
+		}
+		fakeDNS, err = proxy.NewFakeDNS(cfg.FakeDNSL, fakePool, hosts)
+		if err != nil {
+			zap.L().Fatal("fake-ip DNS", zap.Error(err))
+		}
+	}
+
+	shaper, err := proxy.NewShaper(cfg)
+	if err != nil {
+		zap.L().Fatal("shaper", zap.Error(err))
+	}
+
+	connPolicy, err := proxy.NewConnPolicy(cfg)
+	if err != nil {
+		zap.L().Fatal("connection policy", zap.Error(err))
+	}
+	proxy.SetClientKeepAlive(cfg.ClientKeepAlive)
+
+	accessL, err := logger.NewAccess(cfg.AccessLog, cfg.AccessLogMaxSize, cfg.AccessLogBackups)
+	if err != nil {
+		zap.L().Fatal("access log", zap.Error(err))
+	}
+	accessLog := proxy.NewAccessLog(accessL)
+
 	rawDial := sshclient.WrapTimeout(dial)
 	dialCount := func(ctx context.Context, n, a string) (net.Conn, error) {
-		if err := sshclient.RejectIPv6(a, cfg.DNSv6); err != nil {
-			return nil, err
-		}
+		dst := fakePool.ReverseAddr(a)
+		pinned := pinHost(hosts, dst)
+		rec := proxy.NewAccessRecord(proxy.ConnInfoFrom(ctx), dst, pinned)
+		rec.Route = route(a, dst, pinned)
 
-		raw, e := rawDial(ctx, n, a)
-		if e != nil {
-			return nil, e
+		conn, err := func() (net.Conn, error) {
+			if err := shaper.Admit(ctx); err != nil {
+				return nil, err
+			}
+			if err := sshclient.RejectIPv6(pinned, cfg.DNSv6); err != nil {
+				return nil, err
+			}
+			return rawDial(ctx, n, pinned)
+		}()
+		if err != nil {
+			accessLog.Failed(rec, err)
+			return nil, err
 		}
+		rec.Upstream = sshclient.UpstreamOf(conn)
 
-		return metrics.NewTrackConn(&metrics.CountConn{Conn: metrics.NewIdleConn(raw, timeOutIdleConnection)}), nil
+		conn = shaper.Wrap(ctx, conn, dst)
+		ci := proxy.ConnInfoFrom(ctx)
+		labels := metrics.ConnLabels{Listener: ci.Listener, Client: ci.Client, Dst: dst}
+		lifetime, idle := connPolicy.Limits(dst)
+		conn = metrics.NewTrackConn(metrics.NewCountConn(metrics.NewIdleConn(conn, idle, lifetime), labels))
+		return accessLog.Wrap(conn, rec), nil
 	}
 
 	var httpSrv *http.Server
@@ -80,12 +139,53 @@ func main() {
 
 	var socksSrv *proxy.SocksServer
 	if cfg.SocksL != "" {
-		socksSrv, err = proxy.NewSOCKS(cfg, dialCount)
+		socksSrv, err = proxy.NewSOCKS(cfg, dialCount, rawDial, hosts)
 		if err != nil {
 			zap.L().Fatal("SOCKS", zap.Error(err))
 		}
 	}
 
+	var tpSrv *proxy.TransparentServer
+	if cfg.TransparentL != "" {
+		tpSrv, err = proxy.NewTransparent(cfg.TransparentL, cfg.TransparentMode, cfg.TransparentSniff, dialCount)
+		if err != nil {
+			zap.L().Fatal("transparent proxy", zap.Error(err))
+		}
+	}
+
+	var forwards []*proxy.Forward
+	for _, fw := range cfg.Forwards {
+		f, er := proxy.NewForward(fw.Listen, fw.Target, dialCount)
+		if er != nil {
+			zap.L().Fatal("forward", zap.String("local", fw.Listen), zap.Error(er))
+		}
+		forwards = append(forwards, f)
+	}
+
+	var remoteForwards []*proxy.RemoteForward
+	for _, fw := range cfg.RemoteForwards {
+		remoteForwards = append(remoteForwards, proxy.NewRemoteForward(sshCl, fw.Listen, fw.Target, connPolicy))
+	}
+
+	var revSocks *proxy.ReverseSocks
+	if cfg.ReverseSocksL != "" {
+		revSocks, err = proxy.NewReverseSOCKS(cfg, sshCl, connPolicy)
+		if err != nil {
+			zap.L().Fatal("reverse SOCKS", zap.Error(err))
+		}
+	}
+
+	if cfg.NetWatch {
+		sshclient.StartNetWatch(func(string) {
+			// reconnects re-resolve the server uncached, so only the SOCKS
+			// cache needs dropping for the new network
+			if socksSrv != nil {
+				socksSrv.FlushDNS()
+			}
+			sshCl.Kick()
+		})
+	}
+
 	var cmdTun *exec.Cmd
 	if cfg.UseTUN {
 		cmdTun, err = tun.RunExternal(cfg.SocksL)
@@ -94,17 +194,26 @@ func main() {
 		}
 	}
 
-	metrics.StartNetMonitor(cfg.TimeOutMonitor)
+	metrics.StartNetMonitor(cfg.TimeOutMonitor, cfg.TopTalkers)
 	metrics.StartGoroutineMonitor(cfg.TimeOutMonitor)
 	metrics.StartOpenConnectionMonitor(cfg.TimeOutMonitor)
 	metrics.StartMemMonitor(cfg.TimeOutMonitor)
 	metrics.StartCPUMonitor(cfg.TimeOutMonitor)
+	metrics.StartForwardMonitor(cfg.TimeOutMonitor)
+	metrics.StartRTTMonitor(cfg.TimeOutMonitor)
+	if shaper != nil {
+		metrics.StartShaperMonitor(cfg.TimeOutMonitor)
+	}
+
+	// a parent that passed its listeners down can start draining now
+	handoff.Ready()
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
-	<-sig
+	waitStop(sig, cmdTun != nil)
 	zap.L().Info("shutting down…")
 
+	// stop accepting first; tunnels already open keep running
 	ctx, cancel := context.WithTimeout(context.Background(), timeCloser)
 	defer cancel()
 
@@ -114,8 +223,51 @@ func main() {
 	if socksSrv != nil {
 		_ = socksSrv.Shutdown(ctx)
 	}
+	if tpSrv != nil {
+		_ = tpSrv.Shutdown(ctx)
+	}
+	for _, f := range forwards {
+		_ = f.Shutdown(ctx)
+	}
+	for _, f := range remoteForwards {
+		_ = f.Shutdown(ctx)
+	}
+	if revSocks != nil {
+		_ = revSocks.Shutdown(ctx)
+	}
+	if fakeDNS != nil {
+		_ = fakeDNS.Close()
+	}
+
+	drain(cfg.DrainTimeout, sig)
+
 	if cmdTun != nil && cmdTun.Process != nil {
 		_ = cmdTun.Process.Kill()
 	}
+	sshCl.Close()
+}
 
+// route tells how a destination was reached, for the access log.
+func route(asked, reversed, pinned string) string {
+	switch {
+	case reversed != asked:
+		return "fake_ip"
+	case pinned != reversed:
+		return "hosts"
+	}
+	if host, _, err := net.SplitHostPort(pinned); err == nil && net.ParseIP(host) == nil {
+		return "remote_dns"
+	}
+	return "ip"
+}
+
+func pinHost(hosts proxy.Hosts, addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip, ok := hosts.Lookup(host); ok {
+		return net.JoinHostPort(ip.String(), port)
+	}
+	return addr
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/handoff"
+)
+
+const upgradeReadyTimeout = 60 * time.Second
+
+// waitStop blocks until a stop signal arrives or an upgrade hands the
+// listeners to a new process. A failed upgrade leaves this one serving.
+func waitStop(stop <-chan os.Signal, tunRunning bool) {
+	upgrade := notifyUpgrade()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-upgrade:
+			if tunRunning {
+				// the new process would start a second tun2socks on the same device
+				zap.L().Error("upgrade_refused", zap.String("reason", "tun in use"))
+				continue
+			}
+			zap.L().Info("upgrade_start")
+			if err := handoff.Upgrade(upgradeReadyTimeout); err != nil {
+				zap.L().Error("upgrade_failed", zap.Error(err))
+				continue
+			}
+			zap.L().Info("upgrade_handed_over")
+			return
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// notifyUpgrade never fires: there is no SIGUSR2 to trigger a handoff.
+func notifyUpgrade() <-chan os.Signal {
+	return nil
+}
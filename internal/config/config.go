@@ -5,11 +5,31 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
+type Forward struct {
+	Listen string
+	Target string
+}
+
+// RateRule limits destinations matching Match to Rate bytes/s.
+type RateRule struct {
+	Match string
+	Rate  int64
+}
+
+// ConnRule overrides the connection lifetime and idle timeout for
+// destinations matching Match; zero means no limit.
+type ConnRule struct {
+	Match    string
+	Lifetime time.Duration
+	Idle     time.Duration
+}
+
 type Config struct {
 	KeyPath string
 
@@ -18,16 +38,96 @@ type Config struct {
 	Server   string
 	Port     string
 
+	SSHSessions int
+
+	LazyConnect    bool
+	IdleDisconnect time.Duration
+
+	SSHTransport   string
+	SSHTLSSNI      string
+	SSHTLSInsecure bool
+	SSHWSURL       string
+	SSHHTTPProxy   string
+
+	UpstreamProxy    string
+	UpstreamProxyEnv bool
+
+	SSHCiphers        []string
+	SSHKex            []string
+	SSHMACs           []string
+	SSHHostKeyAlgos   []string
+	SSHRekeyThreshold uint64
+	SSHClientVersion  string
+
+	ReconnectInitial     time.Duration
+	ReconnectMax         time.Duration
+	ReconnectMultiplier  float64
+	ReconnectJitter      float64
+	ReconnectMaxAttempts int
+	BreakerThreshold     int
+
+	KeepAliveInterval  time.Duration
+	KeepAliveTimeout   time.Duration
+	KeepAliveMaxMissed int
+
+	ProbeChannels bool
+	ProbeTargets  []string
+	MaxSessions   int64
+	HandoverGrace time.Duration
+
+	NetWatch bool
+
 	SocksL string
 	HTTPL  string
 	DNSv6  bool
 
+	TransparentL     string
+	TransparentMode  string
+	TransparentSniff bool
+
+	Forwards       []Forward
+	RemoteForwards []Forward
+
+	ReverseSocksL          string
+	ReverseSocksUser       string
+	ReverseSocksPass       string
+	ReverseSocksAllowSrc   []string
+	ReverseSocksAllowDst   []string
+	ReverseSocksAllowPorts []string
+
 	UseTUN bool
 
+	ShapeGlobal    int64
+	ShapeListeners map[string]int64
+	ShapeClient    int64
+	ShapeRules     []RateRule
+	QuotaDaily     int64
+
+	ConnLifetime    time.Duration
+	ConnIdle        time.Duration
+	ConnRules       []ConnRule
+	ClientKeepAlive time.Duration
+
+	AccessLog        string
+	AccessLogMaxSize int64
+	AccessLogBackups int
+
+	DrainTimeout time.Duration
+
 	TimeOutMonitorIntSec int64
 	TimeOutMonitor       time.Duration
+	TopTalkers           int
 	Debug                bool
 	DNSServers           []string
+
+	DNSSplit     map[string][]string
+	DNSHosts     []string
+	DNSHostsFile string
+	DNSRemote    bool
+
+	FakeDNSL    string
+	FakeIPRange string
+	FakeIPTTL   time.Duration
 }
 
 func Load() *Config {
@@ -39,17 +139,76 @@ func Load() *Config {
 		SocksL:  getEnv("SOCKS_LSN", ""),
 		HTTPL:   getEnv("HTTP_LSN", ""),
 
+		TransparentL:     getEnv("TRANSPARENT_LSN", ""),
+		TransparentMode:  getEnv("TRANSPARENT_MODE", "redirect"),
+		TransparentSniff: getEnv("TRANSPARENT_SNIFF", "true") == "true",
+
 		UseTUN: getEnv("USE_TUN", "false") == "true",
 
+		ReverseSocksL:    getEnv("REVERSE_SOCKS_LSN", ""),
+		ReverseSocksUser: getEnv("REVERSE_SOCKS_USER", ""),
+		ReverseSocksPass: getEnv("REVERSE_SOCKS_PASSWORD", ""),
+
+		FakeDNSL:    getEnv("FAKE_DNS_LSN", ""),
+		FakeIPRange: getEnv("FAKE_IP_RANGE", "198.18.0.0/15"),
+		FakeIPTTL:   getEnvDuration("FAKE_IP_TTL", 10*time.Minute),
+
 		Login:    getEnv("LOGIN", ""),
 		Password: getEnv("PASSWORD", ""),
 		Server:   getEnv("SERVER", ""),
 		Port:     getEnv("PORT", ""),
 
+		SSHSessions: int(getEnvInt("SSH_SESSIONS", 1)),
+
+		LazyConnect:    getEnv("LAZY_CONNECT", "false") == "true",
+		IdleDisconnect: getEnvDuration("IDLE_DISCONNECT", 0),
+
+		SSHTransport:   getEnv("SSH_TRANSPORT", "tcp"),
+		SSHTLSSNI:      getEnv("SSH_TLS_SNI", ""),
+		SSHTLSInsecure: getEnv("SSH_TLS_INSECURE", "false") == "true",
+		SSHWSURL:       getEnv("SSH_WS_URL", ""),
+		SSHHTTPProxy:   getEnv("SSH_HTTP_PROXY", ""),
+
+		UpstreamProxy:    getEnv("UPSTREAM_PROXY", ""),
+		UpstreamProxyEnv: getEnv("UPSTREAM_PROXY_FROM_ENV", "false") == "true",
+
+		SSHRekeyThreshold: uint64(getEnvInt("SSH_REKEY_THRESHOLD", 0)),
+		SSHClientVersion:  getEnv("SSH_CLIENT_VERSION", ""),
+
+		ReconnectInitial:     getEnvDuration("RECONNECT_INITIAL", 1100*time.Millisecond),
+		ReconnectMax:         getEnvDuration("RECONNECT_MAX", 30*time.Second),
+		ReconnectMultiplier:  getEnvFloat("RECONNECT_MULTIPLIER", 2),
+		ReconnectJitter:      getEnvFloat("RECONNECT_JITTER", 0.2),
+		ReconnectMaxAttempts: int(getEnvInt("RECONNECT_MAX_ATTEMPTS", 0)),
+		BreakerThreshold:     int(getEnvInt("BREAKER_THRESHOLD", 3)),
+
+		KeepAliveInterval:  getEnvDuration("KEEPALIVE_INTERVAL", 1*time.Second),
+		KeepAliveTimeout:   getEnvDuration("KEEPALIVE_TIMEOUT", 10*time.Second),
+		KeepAliveMaxMissed: int(getEnvInt("KEEPALIVE_MAX_MISSED", 3)),
+
+		ProbeChannels: getEnv("PROBE_CHANNELS", "false") == "true",
+		MaxSessions:   getEnvInt("MAX_SESSIONS", 0),
+		HandoverGrace: getEnvDuration("HANDOVER_GRACE", 30*time.Second),
+
+		NetWatch: getEnv("NET_WATCH", "true") == "true",
+
 		DNSv6: getEnv("DNS_IPV6", "false") == "true",
 
+		DNSHostsFile: getEnv("DNS_HOSTS_FILE", ""),
+		DNSRemote:    getEnv("DNS_REMOTE", "false") == "true",
+
+		DrainTimeout: getEnvDuration("DRAIN_TIMEOUT", 30*time.Second),
+
 		TimeOutMonitorIntSec: getEnvInt("TIME_OUT_MONITOR_INT_SEC", 60),
+		TopTalkers:           int(getEnvInt("TOP_TALKERS", 5)),
 		Debug:                getEnv("DEBUG", "false") == "true",
+
+		ConnLifetime:    getEnvDuration("CONN_LIFETIME", 60*time.Minute),
+		ConnIdle:        getEnvDuration("CONN_IDLE", 30*time.Second),
+		ClientKeepAlive: getEnvDuration("CLIENT_KEEPALIVE", 30*time.Second),
+
+		AccessLog:        getEnv("ACCESS_LOG", ""),
+		AccessLogBackups: int(getEnvInt("ACCESS_LOG_BACKUPS", 5)),
 	}
 
 	flag.StringVar(&cfg.Login, "login", cfg.Login, "Login")
@@ -57,18 +216,103 @@ func Load() *Config {
 	flag.StringVar(&cfg.Server, "server", cfg.Server, "Server")
 	flag.StringVar(&cfg.Port, "port", cfg.Port, "Port")
 	flag.StringVar(&cfg.KeyPath, "key", cfg.KeyPath, "path to private key")
+	flag.DurationVar(&cfg.ReconnectInitial, "reconnect-initial", cfg.ReconnectInitial, "Initial reconnect backoff")
+	flag.DurationVar(&cfg.ReconnectMax, "reconnect-max", cfg.ReconnectMax, "Maximum reconnect backoff")
+	flag.Float64Var(&cfg.ReconnectMultiplier, "reconnect-multiplier", cfg.ReconnectMultiplier, "Reconnect backoff multiplier")
+	flag.Float64Var(&cfg.ReconnectJitter, "reconnect-jitter", cfg.ReconnectJitter, "Reconnect backoff jitter fraction (0..1)")
+	flag.IntVar(&cfg.ReconnectMaxAttempts, "reconnect-max-attempts", cfg.ReconnectMaxAttempts, "Reconnect attempts per cycle, 0 = unlimited")
+	flag.IntVar(&cfg.BreakerThreshold, "breaker-threshold", cfg.BreakerThreshold, "Failed attempts before Dial fails fast, 0 = disabled")
+	flag.DurationVar(&cfg.KeepAliveInterval, "keepalive-interval", cfg.KeepAliveInterval, "SSH keepalive interval")
+	flag.DurationVar(&cfg.KeepAliveTimeout, "keepalive-timeout", cfg.KeepAliveTimeout, "SSH keepalive reply timeout")
+	flag.IntVar(&cfg.KeepAliveMaxMissed, "keepalive-max-missed", cfg.KeepAliveMaxMissed, "Consecutive missed keepalives before reconnect")
+	flag.BoolVar(&cfg.ProbeChannels, "probe-channels", cfg.ProbeChannels, "Probe the server channel limit on connect")
+	probeTargets := flag.String("probe-targets", getEnv("PROBE_TARGETS", "1.1.1.1:443,8.8.8.8:443"), "Probe targets dialed from the SSH server")
+	flag.Int64Var(&cfg.MaxSessions, "max-sessions", cfg.MaxSessions, "Static channel limit per SSH transport, 0 = probe/learn")
+	flag.DurationVar(&cfg.HandoverGrace, "handover-grace", cfg.HandoverGrace, "How long an old SSH client may drain after reconnect")
+	flag.BoolVar(&cfg.NetWatch, "net-watch", cfg.NetWatch, "Reconnect at once on network changes and resume from sleep")
+	flag.StringVar(&cfg.SSHTransport, "ssh-transport", cfg.SSHTransport, "SSH transport: tcp, tls, ws or http-connect")
+	flag.StringVar(&cfg.SSHTLSSNI, "ssh-tls-sni", cfg.SSHTLSSNI, "SNI for tls/wss transports")
+	flag.BoolVar(&cfg.SSHTLSInsecure, "ssh-tls-insecure", cfg.SSHTLSInsecure, "Skip TLS certificate verification")
+	flag.StringVar(&cfg.SSHWSURL, "ssh-ws-url", cfg.SSHWSURL, "WebSocket bridge URL (ws:// or wss://)")
+	flag.StringVar(&cfg.SSHHTTPProxy, "ssh-http-proxy", cfg.SSHHTTPProxy, "HTTP CONNECT proxy URL for the http-connect transport")
+	flag.StringVar(&cfg.UpstreamProxy, "upstream-proxy", cfg.UpstreamProxy, "Reach the SSH server via socks5:// or http:// proxy")
+	flag.BoolVar(&cfg.UpstreamProxyEnv, "upstream-proxy-from-env", cfg.UpstreamProxyEnv, "Honor ALL_PROXY / HTTPS_PROXY")
+	sshCiphers := flag.String("ssh-ciphers", getEnv("SSH_CIPHERS", ""), "Cipher preference list")
+	sshKex := flag.String("ssh-kex", getEnv("SSH_KEX", ""), "Key exchange preference list")
+	sshMACs := flag.String("ssh-macs", getEnv("SSH_MACS", ""), "MAC preference list")
+	sshHostKeys := flag.String("ssh-hostkey-algos", getEnv("SSH_HOSTKEY_ALGOS", ""), "Host key algorithm preference list")
+	flag.Uint64Var(&cfg.SSHRekeyThreshold, "ssh-rekey-threshold", cfg.SSHRekeyThreshold, "Bytes before rekeying, 0 = cipher default")
+	flag.StringVar(&cfg.SSHClientVersion, "ssh-client-version", cfg.SSHClientVersion, "SSH client version string")
+	flag.BoolVar(&cfg.LazyConnect, "lazy", cfg.LazyConnect, "Connect SSH on the first proxied request")
+	flag.DurationVar(&cfg.IdleDisconnect, "idle-disconnect", cfg.IdleDisconnect, "Close SSH after this long without channels, 0 = never")
+	flag.IntVar(&cfg.SSHSessions, "ssh-sessions", cfg.SSHSessions, "Parallel SSH transports to stripe channels over")
 
 	flag.StringVar(&cfg.SocksL, "socks", cfg.SocksL, "SOCKS5 listen addr")
 	flag.StringVar(&cfg.HTTPL, "http", cfg.HTTPL, "HTTP  listen addr")
+	flag.StringVar(&cfg.TransparentL, "transparent", cfg.TransparentL, "Transparent proxy listen addr (Linux)")
+	flag.StringVar(&cfg.TransparentMode, "transparent-mode", cfg.TransparentMode, "Transparent mode: redirect or tproxy")
+	forwards := flag.String("forward", getEnv("FORWARDS", ""), "Static forwards: local=target,unix:/path=target")
+	remoteForwards := flag.String("remote-forward", getEnv("REMOTE_FORWARDS", ""), "Remote forwards: server_bind=local_target")
+	flag.StringVar(&cfg.ReverseSocksL, "reverse-socks", cfg.ReverseSocksL, "SOCKS5 listen addr on the SSH server side")
+	flag.StringVar(&cfg.ReverseSocksUser, "reverse-socks-user", cfg.ReverseSocksUser, "Reverse SOCKS username")
+	flag.StringVar(&cfg.ReverseSocksPass, "reverse-socks-password", cfg.ReverseSocksPass, "Reverse SOCKS password")
+	rsSrc := flag.String("reverse-socks-allow-src", getEnv("REVERSE_SOCKS_ALLOW_SRC", ""), "Reverse SOCKS client CIDRs")
+	rsDst := flag.String("reverse-socks-allow-dst", getEnv("REVERSE_SOCKS_ALLOW_DST", ""), "Reverse SOCKS destination CIDRs / domains")
+	rsPorts := flag.String("reverse-socks-allow-ports", getEnv("REVERSE_SOCKS_ALLOW_PORTS", ""), "Reverse SOCKS destination ports")
+	flag.BoolVar(&cfg.TransparentSniff, "transparent-sniff", cfg.TransparentSniff, "Recover hostname from TLS SNI / HTTP Host")
 
 	flag.BoolVar(&cfg.UseTUN, "tun", cfg.UseTUN, "Use TUN")
+	shapeGlobal := flag.String("shape-global", getEnv("SHAPE_GLOBAL", ""), "Total rate limit, bytes/s (K/M/G suffixes)")
+	shapeListeners := flag.String("shape-listeners", getEnv("SHAPE_LISTENERS", ""), "Per-listener rate limits: socks=5M,http=1M,transparent=..,forward=..")
+	shapeClient := flag.String("shape-client", getEnv("SHAPE_CLIENT", ""), "Rate limit per client IP, bytes/s")
+	shapeRules := flag.String("shape-rules", getEnv("SHAPE_RULES", ""), "Per-destination rate limits: *.example.com=1M,10.0.0.0/8=512K,:22=64K")
+	flag.DurationVar(&cfg.ConnLifetime, "conn-lifetime", cfg.ConnLifetime, "Maximum upstream connection lifetime, 0 = unlimited")
+	flag.DurationVar(&cfg.ConnIdle, "conn-idle", cfg.ConnIdle, "Close upstream connections idle this long, 0 = never")
+	connRules := flag.String("conn-rules", getEnv("CONN_RULES", ""), "Per-destination lifetime/idle: :22=0/0,*.db.local=12h/1h")
+	flag.DurationVar(&cfg.ClientKeepAlive, "client-keepalive", cfg.ClientKeepAlive, "TCP keepalive interval on client connections, 0 = off")
+	quotaDaily := flag.String("quota-daily", getEnv("QUOTA_DAILY", ""), "Bytes per client IP per day, e.g. 10G")
+	flag.StringVar(&cfg.FakeDNSL, "fake-dns", cfg.FakeDNSL, "Fake-IP DNS listen addr (udp)")
+	flag.StringVar(&cfg.FakeIPRange, "fake-ip-range", cfg.FakeIPRange, "Fake-IP address pool")
+	flag.DurationVar(&cfg.FakeIPTTL, "fake-ip-ttl", cfg.FakeIPTTL, "Fake-IP mapping lifetime since last use")
 	flag.Int64Var(&cfg.TimeOutMonitorIntSec, "timeout-monitor-int-sec", cfg.TimeOutMonitorIntSec, "Timeout monitor interval in seconds")
+	flag.IntVar(&cfg.TopTalkers, "top-talkers", cfg.TopTalkers, "Busiest connections to log each monitor interval, 0 = off")
 
 	flag.BoolVar(&cfg.DNSv6, "dnsv6", cfg.DNSv6, "Resolve AAAA records too")
+	dnsSplit := flag.String("dns-split", getEnv("DNS_SPLIT", ""), "Per-suffix DNS upstreams: suffix=srv|srv,suffix=remote")
+	dnsHosts := flag.String("dns-hosts", getEnv("DNS_HOSTS", ""), "Static hosts: name=ip,name=ip")
+	flag.StringVar(&cfg.DNSHostsFile, "dns-hosts-file", cfg.DNSHostsFile, "Hosts file in /etc/hosts format")
+	flag.BoolVar(&cfg.DNSRemote, "dns-remote", cfg.DNSRemote, "Let the SSH server resolve hostnames")
 
+	flag.DurationVar(&cfg.DrainTimeout, "drain-timeout", cfg.DrainTimeout, "How long shutdown waits for open connections")
 	flag.BoolVar(&cfg.Debug, "debug", cfg.Debug, "Debug")
+	flag.StringVar(&cfg.AccessLog, "access-log", cfg.AccessLog, "Per-connection access log file, or stdout")
+	accessLogMax := flag.String("access-log-max-size", getEnv("ACCESS_LOG_MAX_SIZE", "100M"), "Rotate the access log at this size")
+	flag.IntVar(&cfg.AccessLogBackups, "access-log-backups", cfg.AccessLogBackups, "Rotated access logs to keep")
 	flag.Parse()
 
+	cfg.DNSSplit = parseSplit(*dnsSplit)
+	cfg.DNSHosts = splitList(*dnsHosts)
+	cfg.ProbeTargets = splitList(*probeTargets)
+	cfg.SSHCiphers = splitList(*sshCiphers)
+	cfg.SSHKex = splitList(*sshKex)
+	cfg.SSHMACs = splitList(*sshMACs)
+	cfg.SSHHostKeyAlgos = splitList(*sshHostKeys)
+	cfg.Forwards = parseForwards("FORWARDS", *forwards)
+	cfg.RemoteForwards = parseForwards("REMOTE_FORWARDS", *remoteForwards)
+	cfg.ReverseSocksAllowSrc = splitList(*rsSrc)
+	cfg.ReverseSocksAllowDst = splitList(*rsDst)
+	cfg.ReverseSocksAllowPorts = splitList(*rsPorts)
+	cfg.ShapeGlobal = parseBytes("SHAPE_GLOBAL", *shapeGlobal)
+	cfg.ShapeClient = parseBytes("SHAPE_CLIENT", *shapeClient)
+	cfg.QuotaDaily = parseBytes("QUOTA_DAILY", *quotaDaily)
+	cfg.ConnRules = parseConnRules(*connRules)
+	cfg.AccessLogMaxSize = parseBytes("ACCESS_LOG_MAX_SIZE", *accessLogMax)
+	cfg.ShapeRules = parseRates("SHAPE_RULES", *shapeRules)
+	cfg.ShapeListeners = make(map[string]int64)
+	for _, r := range parseRates("SHAPE_LISTENERS", *shapeListeners) {
+		cfg.ShapeListeners[r.Match] = r.Rate
+	}
+
 	checkSSHConfig(cfg)
 
 	checkProxyConfig(cfg)
@@ -100,7 +344,7 @@ func checkSSHConfig(cfg *Config) {
 }
 
 func checkProxyConfig(cfg *Config) {
-	if cfg.SocksL == "" && cfg.HTTPL == "" {
+	if cfg.SocksL == "" && cfg.HTTPL == "" && cfg.TransparentL == "" && len(cfg.Forwards) == 0 && len(cfg.RemoteForwards) == 0 && cfg.ReverseSocksL == "" {
 		log.Fatal("Don't use both SOCKS and HTTP")
 	}
 }
@@ -115,3 +359,143 @@ func getEnvInt(k string, def int64) int64 {
 	}
 	return def
 }
+
+func splitList(v string) []string {
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func parseSplit(v string) map[string][]string {
+	out := make(map[string][]string)
+	for _, rule := range splitList(v) {
+		suffix, servers, ok := strings.Cut(rule, "=")
+		if !ok || suffix == "" || servers == "" {
+			log.Fatalf("invalid DNS_SPLIT rule: %q", rule)
+		}
+		suffix = strings.ToLower(strings.Trim(strings.TrimSpace(suffix), "."))
+		for _, srv := range strings.Split(servers, "|") {
+			if srv = strings.TrimSpace(srv); srv != "" {
+				out[suffix] = append(out[suffix], srv)
+			}
+		}
+	}
+	return out
+}
+
+func getEnvDuration(k string, def time.Duration) time.Duration {
+	if v := os.Getenv(k); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid %s: %v", k, err)
+		}
+		return d
+	}
+	return def
+}
+
+func parseForwards(env, v string) []Forward {
+	var out []Forward
+	for _, rule := range splitList(v) {
+		// split on the last "=" so unix socket paths may contain one
+		i := strings.LastIndex(rule, "=")
+		if i <= 0 || i == len(rule)-1 {
+			log.Fatalf("invalid %s rule: %q", env, rule)
+		}
+		out = append(out, Forward{Listen: rule[:i], Target: rule[i+1:]})
+	}
+	return out
+}
+
+func getEnvFloat(k string, def float64) float64 {
+	if v := os.Getenv(k); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Fatalf("invalid %s: %v", k, err)
+		}
+		return f
+	}
+	return def
+}
+
+// parseBytes reads sizes like 512K, 10M or 2G (powers of 1024); empty is 0.
+func parseBytes(env, v string) int64 {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	if v == "" {
+		return 0
+	}
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "B"), "I")
+
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(v, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(v, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(v, "G"):
+		mult = 1 << 30
+	case strings.HasSuffix(v, "T"):
+		mult = 1 << 40
+	}
+	if mult > 1 {
+		v = v[:len(v)-1]
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		log.Fatalf("invalid %s: %q", env, v)
+	}
+	return n * mult
+}
+
+func parseRates(env, v string) []RateRule {
+	var out []RateRule
+	for _, rule := range splitList(v) {
+		i := strings.LastIndex(rule, "=")
+		if i <= 0 || i == len(rule)-1 {
+			log.Fatalf("invalid %s rule: %q", env, rule)
+		}
+		rate := parseBytes(env, rule[i+1:])
+		if rate <= 0 {
+			log.Fatalf("invalid %s rule: %q", env, rule)
+		}
+		out = append(out, RateRule{Match: strings.TrimSpace(rule[:i]), Rate: rate})
+	}
+	return out
+}
+
+func parseConnRules(v string) []ConnRule {
+	var out []ConnRule
+	for _, rule := range splitList(v) {
+		i := strings.LastIndex(rule, "=")
+		if i <= 0 {
+			log.Fatalf("invalid CONN_RULES rule: %q", rule)
+		}
+		lifetime, idle, ok := strings.Cut(rule[i+1:], "/")
+		if !ok {
+			log.Fatalf("invalid CONN_RULES rule, want lifetime/idle: %q", rule)
+		}
+		r := ConnRule{Match: strings.TrimSpace(rule[:i])}
+		var err error
+		if r.Lifetime, err = parseLimit(lifetime); err != nil {
+			log.Fatalf("invalid CONN_RULES rule %q: %v", rule, err)
+		}
+		if r.Idle, err = parseLimit(idle); err != nil {
+			log.Fatalf("invalid CONN_RULES rule %q: %v", rule, err)
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// parseLimit is time.ParseDuration that also takes a bare "0".
+func parseLimit(v string) (time.Duration, error) {
+	if v = strings.TrimSpace(v); v == "0" {
+		return 0, nil
+	}
+	return time.ParseDuration(v)
+}
@@ -0,0 +1,202 @@
+// Package handoff lets listening sockets outlive the process: they can be
+// inherited from systemd socket activation (LISTEN_FDS) or from a previous
+// ssh2proxy that exec'd this binary on SIGUSR2 and now drains its tunnels.
+package handoff
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+const (
+	envListenFDs   = "LISTEN_FDS"
+	envListenPID   = "LISTEN_PID"
+	envListenNames = "LISTEN_FDNAMES"
+	envReadyFD     = "SSH2PROXY_READY_FD"
+
+	firstFD = 3
+)
+
+// socket is a net.Listener or a net.PacketConn.
+type socket interface {
+	Close() error
+}
+
+type entry struct {
+	network string
+	addr    string
+	sock    socket
+}
+
+var (
+	loadOnce  sync.Once
+	mu        sync.Mutex
+	inherited []socket
+	active    []entry
+)
+
+// Listen returns the inherited socket bound to addr if there is one, and
+// otherwise listens with lc. Either way the listener can be handed over.
+func Listen(lc net.ListenConfig, network, addr string) (net.Listener, error) {
+	if sock := take(network, addr); sock != nil {
+		if tl, ok := sock.(*net.TCPListener); ok {
+			return keepAliveListener{tl, lc}, nil
+		}
+		if ln, ok := sock.(net.Listener); ok {
+			return ln, nil
+		}
+	}
+	ln, err := lc.Listen(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+	track(network, addr, ln)
+	return ln, nil
+}
+
+// ListenPacket is Listen for datagram sockets.
+func ListenPacket(network, addr string) (net.PacketConn, error) {
+	if sock := take(network, addr); sock != nil {
+		if pc, ok := sock.(net.PacketConn); ok {
+			return pc, nil
+		}
+	}
+	pc, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	track(network, addr, pc)
+	return pc, nil
+}
+
+// keepAliveListener applies lc's keepalive settings on accept, as a listener
+// from lc.Listen does; an inherited one only knows the runtime defaults.
+type keepAliveListener struct {
+	*net.TCPListener
+	lc net.ListenConfig
+}
+
+func (ln keepAliveListener) Accept() (net.Conn, error) {
+	c, err := ln.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case ln.lc.KeepAlive < 0:
+		_ = c.SetKeepAlive(false)
+	case ln.lc.KeepAliveConfig.Enable:
+		_ = c.SetKeepAliveConfig(ln.lc.KeepAliveConfig)
+	}
+	return c, nil
+}
+
+// Inherited reports whether addr will be served from an inherited socket.
+func Inherited(network, addr string) bool {
+	loadOnce.Do(load)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, sock := range inherited {
+		if sameAddr(network, addr, localAddr(sock)) {
+			return true
+		}
+	}
+	return false
+}
+
+func take(network, addr string) socket {
+	loadOnce.Do(load)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, sock := range inherited {
+		if sameAddr(network, addr, localAddr(sock)) {
+			inherited = append(inherited[:i], inherited[i+1:]...)
+			active = append(active, entry{network, addr, sock})
+			zap.L().Info("handoff_inherited", zap.String("network", network), zap.String("addr", addr))
+			return sock
+		}
+	}
+	return nil
+}
+
+func track(network, addr string, sock socket) {
+	mu.Lock()
+	active = append(active, entry{network, addr, sock})
+	mu.Unlock()
+}
+
+func localAddr(sock socket) net.Addr {
+	switch s := sock.(type) {
+	case net.Listener:
+		return s.Addr()
+	case net.PacketConn:
+		return s.LocalAddr()
+	}
+	return nil
+}
+
+// Ready tells the process that handed the sockets over that this one is
+// serving; it is a no-op for a normal or systemd start.
+func Ready() {
+	loadOnce.Do(load)
+
+	mu.Lock()
+	for _, sock := range inherited {
+		zap.L().Warn("handoff_unused", zap.Stringer("addr", localAddr(sock)))
+		_ = sock.Close()
+	}
+	inherited = nil
+	mu.Unlock()
+
+	notifyParent()
+}
+
+func sameAddr(network, want string, got net.Addr) bool {
+	if got == nil {
+		return false
+	}
+	var (
+		gotIP   net.IP
+		gotPort int
+	)
+	switch ga := got.(type) {
+	case *net.UnixAddr:
+		return network == "unix" && ga.Name == want
+	case *net.TCPAddr:
+		if !strings.HasPrefix(network, "tcp") {
+			return false
+		}
+		gotIP, gotPort = ga.IP, ga.Port
+	case *net.UDPAddr:
+		if !strings.HasPrefix(network, "udp") {
+			return false
+		}
+		gotIP, gotPort = ga.IP, ga.Port
+	default:
+		return false
+	}
+
+	host, port, err := net.SplitHostPort(want)
+	if err != nil || port != strconv.Itoa(gotPort) {
+		return false
+	}
+	if host == "" {
+		return gotIP == nil || gotIP.IsUnspecified()
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return false
+	}
+	for _, ip := range ips {
+		if ip.Equal(gotIP) || (ip.IsUnspecified() && gotIP.IsUnspecified()) {
+			return true
+		}
+	}
+	return false
+}
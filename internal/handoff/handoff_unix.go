@@ -0,0 +1,156 @@
+//go:build !windows
+
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var readyFile *os.File
+
+// load picks up sockets passed as fds 3.. by systemd or by the previous
+// process, and scrubs the variables so child processes do not see them.
+func load() {
+	defer func() {
+		_ = os.Unsetenv(envListenFDs)
+		_ = os.Unsetenv(envListenPID)
+		_ = os.Unsetenv(envListenNames)
+		_ = os.Unsetenv(envReadyFD)
+	}()
+
+	if fd, err := strconv.Atoi(os.Getenv(envReadyFD)); err == nil {
+		// keep it from leaking into tun2socks, or the parent never sees EOF
+		syscall.CloseOnExec(fd)
+		readyFile = os.NewFile(uintptr(fd), "handoff-ready")
+	}
+
+	n, err := strconv.Atoi(os.Getenv(envListenFDs))
+	if err != nil || n <= 0 {
+		return
+	}
+	if pid := os.Getenv(envListenPID); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	for i := 0; i < n; i++ {
+		f := os.NewFile(uintptr(firstFD+i), fmt.Sprintf("listen-fd-%d", i))
+		var sock socket
+		if ln, err := net.FileListener(f); err == nil {
+			sock = ln
+		} else if pc, err := net.FilePacketConn(f); err == nil {
+			sock = pc
+		}
+		_ = f.Close()
+		if sock == nil {
+			zap.L().Warn("handoff_fd_skip", zap.Int("fd", firstFD+i))
+			continue
+		}
+		inherited = append(inherited, sock)
+	}
+}
+
+func notifyParent() {
+	if readyFile == nil {
+		return
+	}
+	_, _ = readyFile.Write([]byte{1})
+	_ = readyFile.Close()
+	readyFile = nil
+}
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Upgrade starts the current executable with the same arguments and every
+// active socket passed down, and waits until it reports Ready. On success
+// the caller should stop accepting and drain; the sockets stay open in the
+// new process. On failure nothing changes and the caller keeps serving.
+func Upgrade(timeout time.Duration) error {
+	loadOnce.Do(load)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	list := append([]entry(nil), active...)
+	mu.Unlock()
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	for _, e := range list {
+		fl, ok := e.sock.(filer)
+		if !ok {
+			return fmt.Errorf("handoff: %s socket %s cannot be passed on", e.network, e.addr)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	env := append(os.Environ(),
+		envListenFDs+"="+strconv.Itoa(len(files)),
+		envReadyFD+"="+strconv.Itoa(firstFD+len(files)),
+	)
+	proc, err := os.StartProcess(exe, os.Args, &os.ProcAttr{
+		Env:   env,
+		Files: append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, append(files, wr)...),
+	})
+	_ = wr.Close()
+	if err != nil {
+		return err
+	}
+	zap.L().Info("handoff_started", zap.Int("pid", proc.Pid), zap.Int("sockets", len(files)))
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if n, _ := rd.Read(buf); n == 1 {
+			ready <- nil
+			return
+		}
+		ready <- errors.New("handoff: new process exited before it was ready")
+	}()
+
+	select {
+	case err = <-ready:
+	case <-time.After(timeout):
+		err = errors.New("handoff: new process not ready in time")
+	}
+	if err != nil {
+		_ = proc.Kill()
+		_, _ = proc.Wait()
+		return err
+	}
+	_ = proc.Release()
+
+	// the new process owns the unix socket paths now
+	for _, e := range list {
+		if ul, ok := e.sock.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package handoff
+
+import (
+	"errors"
+	"time"
+)
+
+func load() {}
+
+func notifyParent() {}
+
+func Upgrade(time.Duration) error {
+	return errors.New("handoff: not supported on windows")
+}
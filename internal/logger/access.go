@@ -0,0 +1,35 @@
+package logger
+
+import (
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewAccess returns a logger for the connection audit trail, kept apart from
+// the debug log. path "stdout" writes to standard output; an empty path
+// disables it (nil logger).
+func NewAccess(path string, maxSize int64, backups int) (*zap.Logger, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	var ws zapcore.WriteSyncer = zapcore.AddSync(os.Stdout)
+	if path != "stdout" {
+		f, err := openRotating(path, maxSize, backups)
+		if err != nil {
+			return nil, err
+		}
+		ws = f
+	}
+
+	encCfg := zapcore.EncoderConfig{
+		TimeKey:     "ts",
+		MessageKey:  "msg",
+		EncodeTime:  zapcore.ISO8601TimeEncoder,
+		EncodeLevel: zapcore.LowercaseLevelEncoder,
+	}
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encCfg), ws, zap.InfoLevel)
+	return zap.New(core), nil
+}
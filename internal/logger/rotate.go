@@ -0,0 +1,74 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile renames path to path.1 (path.1 to path.2, …) once it grows
+// past maxSize and keeps at most backups old files.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+
+	f    *os.File
+	size int64
+}
+
+func openRotating(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	w := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotatingFile) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	w.f, w.size = f, fi.Size()
+	return nil
+}
+
+func (w *rotatingFile) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotatingFile) rotate() error {
+	_ = w.f.Close()
+	if w.backups > 0 {
+		for i := w.backups - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+		}
+		_ = os.Rename(w.path, w.path+".1")
+	} else {
+		_ = os.Remove(w.path)
+	}
+	return w.open()
+}
+
+func (w *rotatingFile) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Sync()
+}
@@ -0,0 +1,23 @@
+package metrics
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrNoCloseWrite is returned by CloseWrite for conns that cannot half-close.
+var ErrNoCloseWrite = errors.New("conn does not support half-close")
+
+// CloseWrite half-closes c if it, or the conn it wraps, can. Wrappers use it
+// to pass a half-close through to the conn underneath.
+func CloseWrite(c net.Conn) error {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return ErrNoCloseWrite
+}
+
+func (c *TrackConn) CloseWrite() error   { return CloseWrite(c.Conn) }
+func (c *CountConn) CloseWrite() error   { return CloseWrite(c.Conn) }
+func (c *IdleConn) CloseWrite() error    { return CloseWrite(c.Conn) }
+func (c *forwardConn) CloseWrite() error { return CloseWrite(c.Conn) }
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type ForwardStats struct {
+	Name string
+
+	active   int64
+	total    int64
+	failed   int64
+	bytesUp  int64
+	bytesDwn int64
+}
+
+var (
+	forwardsMu sync.Mutex
+	forwards   = map[string]*ForwardStats{}
+)
+
+func NewForwardStats(name string) *ForwardStats {
+	forwardsMu.Lock()
+	defer forwardsMu.Unlock()
+
+	if st, ok := forwards[name]; ok {
+		return st
+	}
+	st := &ForwardStats{Name: name}
+	forwards[name] = st
+	return st
+}
+
+func (s *ForwardStats) Opened() {
+	atomic.AddInt64(&s.active, 1)
+	atomic.AddInt64(&s.total, 1)
+}
+
+func (s *ForwardStats) Closed() { atomic.AddInt64(&s.active, -1) }
+
+func (s *ForwardStats) Failed() { atomic.AddInt64(&s.failed, 1) }
+
+// Wrap counts bytes on the upstream side: writes go up, reads come down.
+func (s *ForwardStats) Wrap(c net.Conn) net.Conn {
+	return &forwardConn{Conn: c, st: s}
+}
+
+type forwardConn struct {
+	net.Conn
+	st *ForwardStats
+}
+
+func (c *forwardConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.st.bytesDwn, int64(n))
+	return n, err
+}
+
+func (c *forwardConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.st.bytesUp, int64(n))
+	return n, err
+}
+
+func StartForwardMonitor(periodForwardStat time.Duration) {
+	go func() {
+		t := time.NewTicker(periodForwardStat)
+		defer t.Stop()
+		for range t.C {
+			forwardsMu.Lock()
+			names := make([]string, 0, len(forwards))
+			for name := range forwards {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			list := make([]*ForwardStats, 0, len(names))
+			for _, name := range names {
+				list = append(list, forwards[name])
+			}
+			forwardsMu.Unlock()
+
+			for _, st := range list {
+				zap.L().Debug("forward",
+					zap.String("name", st.Name),
+					zap.Int64("active", atomic.LoadInt64(&st.active)),
+					zap.Int64("total", atomic.LoadInt64(&st.total)),
+					zap.Int64("failed", atomic.LoadInt64(&st.failed)),
+					zap.Int64("bytes_up", atomic.LoadInt64(&st.bytesUp)),
+					zap.Int64("bytes_down", atomic.LoadInt64(&st.bytesDwn)),
+				)
+			}
+		}
+	}()
+}
@@ -2,14 +2,11 @@ package metrics
 
 import (
 	"runtime"
-	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 )
 
-var bytes int64
-
 func StartGoroutineMonitor(timeOutGorutineMonitor time.Duration) {
 	go func() {
 		t := time.NewTicker(timeOutGorutineMonitor)
@@ -19,9 +16,3 @@ func StartGoroutineMonitor(timeOutGorutineMonitor time.Duration) {
 		}
 	}()
 }
-
-func Add(n int64) { atomic.AddInt64(&bytes, n) }
-
-func Swap() int64 {
-	return atomic.SwapInt64(&bytes, 0)
-}
@@ -1,58 +1,105 @@
 package metrics
 
 import (
+	"errors"
 	"net"
+	"sync"
 	"time"
 )
 
+var (
+	ErrIdleTimeout = errors.New("connection idle timeout")
+	ErrLifetime    = errors.New("connection lifetime exceeded")
+)
+
+// IdleConn closes the wrapped conn after idleTO without I/O or once it has
+// been open for lifetime; zero disables either limit. Reads and writes
+// after such a close fail with ErrIdleTimeout or ErrLifetime.
 type IdleConn struct {
 	net.Conn
 	idleTO   time.Duration
+	lifetime time.Duration
 	lastIO   chan struct{}
 	shutdown chan struct{}
+	once     sync.Once
+
+	mu     sync.Mutex
+	reason error
 }
 
-func NewIdleConn(c net.Conn, idle time.Duration) *IdleConn {
+func NewIdleConn(c net.Conn, idle, lifetime time.Duration) *IdleConn {
 	ic := &IdleConn{
 		Conn:     c,
 		idleTO:   idle,
+		lifetime: lifetime,
 		lastIO:   make(chan struct{}, 1),
 		shutdown: make(chan struct{}),
 	}
-	go ic.watchdog()
+	if idle > 0 || lifetime > 0 {
+		go ic.watchdog()
+	}
 	return ic
 }
 
 func (c *IdleConn) Read(p []byte) (int, error) {
 	n, err := c.Conn.Read(p)
-	select {
-	case c.lastIO <- struct{}{}:
-	default:
-	}
-	return n, err
+	c.touch()
+	return n, c.why(err)
 }
 
 func (c *IdleConn) Write(p []byte) (int, error) {
 	n, err := c.Conn.Write(p)
+	c.touch()
+	return n, c.why(err)
+}
+
+func (c *IdleConn) touch() {
 	select {
 	case c.lastIO <- struct{}{}:
 	default:
 	}
-	return n, err
+}
+
+// why replaces the "use of closed connection" error caused by the watchdog
+// with the reason it closed the conn.
+func (c *IdleConn) why(err error) error {
+	if err == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reason != nil {
+		return c.reason
+	}
+	return err
 }
 
 func (c *IdleConn) watchdog() {
-	timer := time.NewTimer(c.idleTO)
-	defer timer.Stop()
+	var idle, life <-chan time.Time
+	var idleTimer *time.Timer
+	if c.idleTO > 0 {
+		idleTimer = time.NewTimer(c.idleTO)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+	if c.lifetime > 0 {
+		lifeTimer := time.NewTimer(c.lifetime)
+		defer lifeTimer.Stop()
+		life = lifeTimer.C
+	}
+
 	for {
 		select {
 		case <-c.lastIO:
-			if !timer.Stop() {
-				<-timer.C
+			// no drain needed: since Go 1.23 Reset discards a pending tick
+			if idleTimer != nil {
+				idleTimer.Reset(c.idleTO)
 			}
-			timer.Reset(c.idleTO)
-		case <-timer.C:
-			_ = c.Conn.Close()
+		case <-idle:
+			c.expire(ErrIdleTimeout)
+			return
+		case <-life:
+			c.expire(ErrLifetime)
 			return
 		case <-c.shutdown:
 			return
@@ -60,7 +107,14 @@ func (c *IdleConn) watchdog() {
 	}
 }
 
+func (c *IdleConn) expire(reason error) {
+	c.mu.Lock()
+	c.reason = reason
+	c.mu.Unlock()
+	_ = c.Conn.Close()
+}
+
 func (c *IdleConn) Close() error {
-	close(c.shutdown)
+	c.once.Do(func() { close(c.shutdown) })
 	return c.Conn.Close()
 }
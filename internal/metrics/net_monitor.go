@@ -2,41 +2,168 @@ package metrics
 
 import (
 	"net"
+	"sort"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
 )
 
-func StartNetMonitor(timeOutNetStats time.Duration) {
-	go func() {
-		for {
-			now := time.Now()
-			next := now.Truncate(timeOutNetStats).Add(timeOutNetStats)
-			time.Sleep(time.Until(next))
+// ConnLabels says whose traffic a CountConn carries.
+type ConnLabels struct {
+	Listener string
+	Client   string
+	Dst      string
+}
 
-			b := Swap()
+var (
+	global dirStats
 
-			if b == 0 {
-				continue
-			}
+	connsMu   sync.Mutex
+	conns     = map[*CountConn]struct{}{}
+	listeners = map[string]*dirStats{}
+)
 
-			seconds := timeOutNetStats.Seconds()
-			mbps := float64(b*8) / (seconds * 1e6)
+func listenerStats(name string) *dirStats {
+	connsMu.Lock()
+	defer connsMu.Unlock()
+	st, ok := listeners[name]
+	if !ok {
+		st = &dirStats{}
+		listeners[name] = st
+	}
+	return st
+}
 
-			zap.L().Debug("traffic", zap.Float64("mbps", mbps), zap.Int64("bytes", b))
-		}
-	}()
+// CountConn counts the bytes of one upstream connection: writes go up,
+// reads come down. Totals roll up into its listener and the global counters.
+type CountConn struct {
+	net.Conn
+	Labels ConnLabels
+
+	stats    dirStats
+	listener *dirStats
+	once     sync.Once
 }
 
-type CountConn struct{ net.Conn }
+func NewCountConn(c net.Conn, labels ConnLabels) *CountConn {
+	cc := &CountConn{Conn: c, Labels: labels, listener: listenerStats(labels.Listener)}
+	connsMu.Lock()
+	conns[cc] = struct{}{}
+	connsMu.Unlock()
+	return cc
+}
 
 func (c *CountConn) Read(p []byte) (int, error) {
 	n, err := c.Conn.Read(p)
-	Add(int64(n))
+	if n > 0 {
+		now := time.Now()
+		c.stats.addDown(int64(n), now)
+		c.listener.addDown(int64(n), now)
+		global.addDown(int64(n), now)
+	}
 	return n, err
 }
+
 func (c *CountConn) Write(p []byte) (int, error) {
 	n, err := c.Conn.Write(p)
-	Add(int64(n))
+	if n > 0 {
+		now := time.Now()
+		c.stats.addUp(int64(n), now)
+		c.listener.addUp(int64(n), now)
+		global.addUp(int64(n), now)
+	}
 	return n, err
 }
+
+func (c *CountConn) Close() error {
+	c.once.Do(func() {
+		connsMu.Lock()
+		delete(conns, c)
+		connsMu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// Talker is one connection ranked by its current throughput.
+type Talker struct {
+	ConnLabels
+	Up, Down float64 // bytes/s over the last 10s
+}
+
+// TopTalkers returns the n busiest open connections.
+func TopTalkers(n int) []Talker {
+	now := time.Now()
+	connsMu.Lock()
+	list := make([]Talker, 0, len(conns))
+	for c := range conns {
+		list = append(list, Talker{
+			ConnLabels: c.Labels,
+			Up:         c.stats.up.rate(10, now),
+			Down:       c.stats.down.rate(10, now),
+		})
+	}
+	connsMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Up+list[i].Down > list[j].Up+list[j].Down })
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+func rateFields(st *dirStats, now time.Time) []zap.Field {
+	up, down := st.totals()
+	return []zap.Field{
+		zap.Float64("up_1s", st.up.rate(1, now)),
+		zap.Float64("down_1s", st.down.rate(1, now)),
+		zap.Float64("up_10s", st.up.rate(10, now)),
+		zap.Float64("down_10s", st.down.rate(10, now)),
+		zap.Float64("up_60s", st.up.rate(60, now)),
+		zap.Float64("down_60s", st.down.rate(60, now)),
+		zap.Int64("bytes_up", up),
+		zap.Int64("bytes_down", down),
+	}
+}
+
+// StartNetMonitor logs upload/download rates in bytes/s over 1s, 10s and
+// 60s windows, globally and per listener, plus the topN busiest connections.
+func StartNetMonitor(timeOutNetStats time.Duration, topN int) {
+	go func() {
+		t := time.NewTicker(timeOutNetStats)
+		defer t.Stop()
+		for range t.C {
+			now := time.Now()
+			zap.L().Debug("traffic", rateFields(&global, now)...)
+
+			connsMu.Lock()
+			names := make([]string, 0, len(listeners))
+			for name := range listeners {
+				names = append(names, name)
+			}
+			connsMu.Unlock()
+			sort.Strings(names)
+			for _, name := range names {
+				fields := append([]zap.Field{zap.String("listener", name)}, rateFields(listenerStats(name), now)...)
+				zap.L().Debug("traffic_listener", fields...)
+			}
+
+			if topN <= 0 {
+				continue
+			}
+			for i, tk := range TopTalkers(topN) {
+				if tk.Up+tk.Down == 0 {
+					break
+				}
+				zap.L().Debug("top_talker",
+					zap.Int("rank", i+1),
+					zap.String("listener", tk.Listener),
+					zap.String("client", tk.Client),
+					zap.String("dst", tk.Dst),
+					zap.Float64("up_10s", tk.Up),
+					zap.Float64("down_10s", tk.Down),
+				)
+			}
+		}
+	}()
+}
@@ -2,13 +2,19 @@ package metrics
 
 import (
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 )
 
-var openConns int64
+var (
+	openConns int64
+
+	trackedMu sync.Mutex
+	tracked   = map[*TrackConn]struct{}{}
+)
 
 type TrackConn struct {
 	net.Conn
@@ -16,17 +22,58 @@ type TrackConn struct {
 }
 
 func NewTrackConn(c net.Conn) *TrackConn {
+	tc := &TrackConn{Conn: c}
 	atomic.AddInt64(&openConns, 1)
-	return &TrackConn{Conn: c}
+	trackedMu.Lock()
+	tracked[tc] = struct{}{}
+	trackedMu.Unlock()
+	return tc
 }
 
 func (c *TrackConn) Close() error {
 	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
 		atomic.AddInt64(&openConns, -1)
+		trackedMu.Lock()
+		delete(tracked, c)
+		trackedMu.Unlock()
 	}
 	return c.Conn.Close()
 }
 
+// NewTrackListener tracks every connection ln accepts, for listeners whose
+// conns do not come through the proxy dialer (remote forwards).
+func NewTrackListener(ln net.Listener) net.Listener {
+	return trackListener{ln}
+}
+
+type trackListener struct{ net.Listener }
+
+func (ln trackListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewTrackConn(c), nil
+}
+
+func OpenConns() int64 { return atomic.LoadInt64(&openConns) }
+
+// CloseAll force-closes every tracked connection and returns how many
+// were still open.
+func CloseAll() int {
+	trackedMu.Lock()
+	list := make([]*TrackConn, 0, len(tracked))
+	for c := range tracked {
+		list = append(list, c)
+	}
+	trackedMu.Unlock()
+
+	for _, c := range list {
+		_ = c.Close()
+	}
+	return len(list)
+}
+
 func StartOpenConnectionMonitor(periodOpenStat time.Duration) {
 	go func() {
 		t := time.NewTicker(periodOpenStat)
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const rateSlots = 61 // 60 full seconds plus the current one
+
+// rateWindow keeps per-second byte counts for the last minute so rates over
+// any window up to 60s can be read without resetting anything.
+type rateWindow struct {
+	mu    sync.Mutex
+	slots [rateSlots]int64
+	head  int64 // unix second of the newest slot
+}
+
+func (w *rateWindow) add(n int64, now time.Time) {
+	w.mu.Lock()
+	w.advance(now.Unix())
+	w.slots[w.head%rateSlots] += n
+	w.mu.Unlock()
+}
+
+// advance zeroes the slots of the seconds that passed without traffic.
+func (w *rateWindow) advance(sec int64) {
+	if sec <= w.head {
+		return
+	}
+	gap := sec - w.head
+	if gap > rateSlots {
+		gap = rateSlots
+	}
+	for i := int64(1); i <= gap; i++ {
+		w.slots[(w.head+i)%rateSlots] = 0
+	}
+	w.head = sec
+}
+
+// rate returns bytes/s over the last span completed seconds.
+func (w *rateWindow) rate(span int, now time.Time) float64 {
+	if span < 1 || span >= rateSlots {
+		span = rateSlots - 1
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.advance(now.Unix())
+
+	var sum int64
+	for i := int64(1); i <= int64(span); i++ {
+		sum += w.slots[(w.head-i+rateSlots)%rateSlots]
+	}
+	return float64(sum) / float64(span)
+}
+
+// dirStats counts one flow in both directions: up is towards the SSH
+// upstream, down towards the client.
+type dirStats struct {
+	up, down     rateWindow
+	totUp, totDn int64
+}
+
+func (s *dirStats) addUp(n int64, now time.Time) {
+	s.up.add(n, now)
+	atomic.AddInt64(&s.totUp, n)
+}
+
+func (s *dirStats) addDown(n int64, now time.Time) {
+	s.down.add(n, now)
+	atomic.AddInt64(&s.totDn, n)
+}
+
+func (s *dirStats) totals() (up, down int64) {
+	return atomic.LoadInt64(&s.totUp), atomic.LoadInt64(&s.totDn)
+}
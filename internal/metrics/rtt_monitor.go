@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const rttAlpha = 0.2
+
+type rttStat struct {
+	last time.Duration
+	avg  time.Duration
+}
+
+var (
+	rttMu sync.Mutex
+	rtts  = map[int]*rttStat{}
+)
+
+// ObserveRTT folds one keepalive round trip of an SSH link into its
+// exponential moving average and returns the new average.
+func ObserveRTT(link int, d time.Duration) time.Duration {
+	rttMu.Lock()
+	defer rttMu.Unlock()
+
+	st, ok := rtts[link]
+	if !ok {
+		st = &rttStat{avg: d}
+		rtts[link] = st
+	}
+	st.last = d
+	st.avg = time.Duration(rttAlpha*float64(d) + (1-rttAlpha)*float64(st.avg))
+	return st.avg
+}
+
+func StartRTTMonitor(periodRTTStat time.Duration) {
+	go func() {
+		t := time.NewTicker(periodRTTStat)
+		defer t.Stop()
+		for range t.C {
+			rttMu.Lock()
+			links := make([]int, 0, len(rtts))
+			for l := range rtts {
+				links = append(links, l)
+			}
+			sort.Ints(links)
+			for _, l := range links {
+				zap.L().Debug("ssh_rtt",
+					zap.Int("link", l),
+					zap.Duration("last", rtts[l].last),
+					zap.Duration("avg", rtts[l].avg),
+				)
+			}
+			rttMu.Unlock()
+		}
+	}()
+}
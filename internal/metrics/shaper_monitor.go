@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type throttleStat struct {
+	waits  int64
+	waited time.Duration
+}
+
+var (
+	shaperMu     sync.Mutex
+	throttles    = map[string]*throttleStat{}
+	quotaRejects = map[string]int64{}
+)
+
+// ObserveThrottle records that a connection slept for d on the bucket of
+// scope (global, listener:x, client:x, rule:x).
+func ObserveThrottle(scope string, d time.Duration) {
+	shaperMu.Lock()
+	defer shaperMu.Unlock()
+
+	st, ok := throttles[scope]
+	if !ok {
+		st = &throttleStat{}
+		throttles[scope] = st
+	}
+	st.waits++
+	st.waited += d
+}
+
+func ObserveQuotaReject(client string) {
+	shaperMu.Lock()
+	quotaRejects[client]++
+	shaperMu.Unlock()
+}
+
+// StartShaperMonitor logs, per period, how often and how long each bucket
+// held connections back, summed over connections.
+func StartShaperMonitor(periodShaperStat time.Duration) {
+	go func() {
+		t := time.NewTicker(periodShaperStat)
+		defer t.Stop()
+		for range t.C {
+			shaperMu.Lock()
+			scopes := make([]string, 0, len(throttles))
+			for s := range throttles {
+				scopes = append(scopes, s)
+			}
+			sort.Strings(scopes)
+			for _, s := range scopes {
+				st := throttles[s]
+				zap.L().Debug("shaper_throttle",
+					zap.String("scope", s),
+					zap.Int64("waits", st.waits),
+					zap.Duration("waited", st.waited),
+				)
+			}
+			for client, n := range quotaRejects {
+				zap.L().Debug("shaper_quota_rejects", zap.String("client", client), zap.Int64("rejects", n))
+			}
+			throttles = map[string]*throttleStat{}
+			quotaRejects = map[string]int64{}
+			shaperMu.Unlock()
+		}
+	}()
+}
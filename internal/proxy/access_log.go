@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/metrics"
+)
+
+// AccessLog writes one record per proxied connection, after it closes, to a
+// sink of its own. Without a sink the record still goes to the debug log so
+// close reasons are never lost.
+type AccessLog struct {
+	l *zap.Logger
+}
+
+func NewAccessLog(l *zap.Logger) *AccessLog {
+	return &AccessLog{l: l}
+}
+
+// AccessRecord is what is known about a connection when it is dialed.
+type AccessRecord struct {
+	Start    time.Time
+	Info     ConnInfo
+	Host     string
+	IP       string
+	Port     int
+	Route    string
+	Upstream string
+}
+
+// NewAccessRecord fills the destination from requested (what the client
+// asked for, after fake-IP reversal) and dialed (what went to the SSH server).
+func NewAccessRecord(ci ConnInfo, requested, dialed string) AccessRecord {
+	rec := AccessRecord{Start: time.Now(), Info: ci, Host: ci.Host}
+	if h, _, err := net.SplitHostPort(requested); err == nil && rec.Host == "" && net.ParseIP(h) == nil {
+		rec.Host = h
+	}
+	if h, p, err := net.SplitHostPort(dialed); err == nil {
+		if net.ParseIP(h) != nil {
+			rec.IP = h
+		}
+		rec.Port, _ = strconv.Atoi(p)
+	}
+	return rec
+}
+
+// Wrap counts bytes on c and logs rec when it is closed.
+func (a *AccessLog) Wrap(c net.Conn, rec AccessRecord) net.Conn {
+	return &accessConn{Conn: c, log: a, rec: rec}
+}
+
+// Failed logs a connection that never got an upstream.
+func (a *AccessLog) Failed(rec AccessRecord, err error) {
+	a.write(rec, 0, 0, CloseDialFailed, err)
+}
+
+// Close reasons recorded in the access log.
+const (
+	CloseClient     = "client"
+	CloseUpstream   = "upstream"
+	CloseIdle       = "idle"
+	CloseLifetime   = "lifetime"
+	CloseQuota      = "quota"
+	CloseDialFailed = "dial_failed"
+)
+
+// closeReason maps an upstream I/O error to why the connection ends.
+func closeReason(err error) string {
+	switch {
+	case errors.Is(err, metrics.ErrIdleTimeout):
+		return CloseIdle
+	case errors.Is(err, metrics.ErrLifetime):
+		return CloseLifetime
+	case errors.Is(err, ErrQuotaExceeded):
+		return CloseQuota
+	}
+	return CloseUpstream
+}
+
+func (a *AccessLog) write(rec AccessRecord, up, down int64, reason string, err error) {
+	fields := []zap.Field{
+		zap.Time("start", rec.Start),
+		zap.String("listener", rec.Info.Listener),
+		zap.String("client", rec.Info.ClientAddr),
+		zap.String("user", rec.Info.User),
+		zap.String("host", rec.Host),
+		zap.String("ip", rec.IP),
+		zap.Int("port", rec.Port),
+		zap.String("route", rec.Route),
+		zap.String("upstream", rec.Upstream),
+		zap.Int64("bytes_up", up),
+		zap.Int64("bytes_down", down),
+		zap.Duration("duration", time.Since(rec.Start)),
+		zap.String("close_reason", reason),
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		fields = append(fields, zap.Error(err))
+	}
+
+	if a.l == nil {
+		zap.L().Debug("conn_closed", fields...)
+		return
+	}
+	a.l.Info("access", fields...)
+}
+
+type accessConn struct {
+	net.Conn
+	log *AccessLog
+	rec AccessRecord
+
+	up   int64
+	down int64
+
+	reasonMu sync.Mutex
+	reason   string
+	err      error
+	once     sync.Once
+}
+
+// setReason keeps the first reason; whatever follows is a consequence.
+func (c *accessConn) setReason(reason string, err error) {
+	c.reasonMu.Lock()
+	if c.reason == "" {
+		c.reason, c.err = reason, err
+	}
+	c.reasonMu.Unlock()
+}
+
+func (c *accessConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.down, int64(n))
+	if err != nil {
+		c.setReason(closeReason(err), err)
+	}
+	return n, err
+}
+
+func (c *accessConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.up, int64(n))
+	if err != nil {
+		c.setReason(closeReason(err), err)
+	}
+	return n, err
+}
+
+// CloseWrite passes on the client's EOF, so the client ended the exchange
+// even if the upstream's EOF arrives afterwards.
+func (c *accessConn) CloseWrite() error {
+	c.setReason(CloseClient, nil)
+	return metrics.CloseWrite(c.Conn)
+}
+
+func (c *accessConn) Close() error {
+	c.once.Do(func() {
+		c.setReason(CloseClient, nil)
+		c.log.write(c.rec, atomic.LoadInt64(&c.up), atomic.LoadInt64(&c.down), c.reason, c.err)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/config"
+)
+
+// ConnPolicy picks the idle timeout and maximum lifetime of an upstream
+// connection: the first matching rule wins, otherwise the global defaults.
+// Zero means no limit.
+type ConnPolicy struct {
+	lifetime time.Duration
+	idle     time.Duration
+	rules    []connRule
+}
+
+type connRule struct {
+	match    destMatch
+	lifetime time.Duration
+	idle     time.Duration
+}
+
+func NewConnPolicy(cfg *config.Config) (*ConnPolicy, error) {
+	p := &ConnPolicy{lifetime: cfg.ConnLifetime, idle: cfg.ConnIdle}
+	for _, r := range cfg.ConnRules {
+		match, err := newDestMatch(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("conn policy: %w", err)
+		}
+		p.rules = append(p.rules, connRule{match: match, lifetime: r.Lifetime, idle: r.Idle})
+	}
+	return p, nil
+}
+
+func (p *ConnPolicy) Limits(dst string) (lifetime, idle time.Duration) {
+	if host, port, ok := splitDest(dst); ok {
+		for _, r := range p.rules {
+			if r.match(host, port) {
+				return r.lifetime, r.idle
+			}
+		}
+	}
+	return p.lifetime, p.idle
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"context"
+	"net"
+
+	"github.com/armon/go-socks5"
+)
+
+const (
+	ListenerSOCKS       = "socks"
+	ListenerHTTP        = "http"
+	ListenerTransparent = "transparent"
+	ListenerForward     = "forward"
+)
+
+// ConnInfo says which listener and client an upstream dial is made for;
+// listeners put it on the dial context.
+type ConnInfo struct {
+	Listener string
+	Client   string
+
+	// ClientAddr keeps the port; User and Host (the name the client asked
+	// for, before any local resolution) are set when the protocol has them.
+	ClientAddr string
+	User       string
+	Host       string
+}
+
+type connInfoKey struct{}
+
+// WithConnInfo tags ctx with the listener and the client IP taken from
+// remote ("ip:port" or a bare IP).
+func WithConnInfo(ctx context.Context, listener, remote string) context.Context {
+	return WithConnInfoOf(ctx, ConnInfo{Listener: listener, ClientAddr: remote})
+}
+
+// WithConnInfoOf tags ctx with ci, deriving Client from ClientAddr.
+func WithConnInfoOf(ctx context.Context, ci ConnInfo) context.Context {
+	ci.Client = ci.ClientAddr
+	if host, _, err := net.SplitHostPort(ci.ClientAddr); err == nil {
+		ci.Client = host
+	}
+	return context.WithValue(ctx, connInfoKey{}, ci)
+}
+
+func ConnInfoFrom(ctx context.Context) ConnInfo {
+	ci, _ := ctx.Value(connInfoKey{}).(ConnInfo)
+	return ci
+}
+
+// socksConnInfo lets every SOCKS request through and tags its context.
+type socksConnInfo struct{}
+
+func (socksConnInfo) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	ci := ConnInfo{Listener: ListenerSOCKS}
+	if req.RemoteAddr != nil {
+		ci.ClientAddr = req.RemoteAddr.Address()
+	}
+	if req.AuthContext != nil {
+		ci.User = req.AuthContext.Payload["Username"]
+	}
+	if req.DestAddr != nil {
+		ci.Host = req.DestAddr.FQDN
+	}
+	return WithConnInfoOf(ctx, ci), true
+}
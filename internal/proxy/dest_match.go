@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// destMatch tests a normalised destination host and port.
+type destMatch func(host string, port int) bool
+
+// newDestMatch understands ":port", a CIDR, an IP, and a domain suffix
+// with or without a leading "*.". Rules of the shaper and of the connection
+// policy share this syntax.
+func newDestMatch(pattern string) (destMatch, error) {
+	switch {
+	case strings.HasPrefix(pattern, ":"):
+		port, err := strconv.Atoi(pattern[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid port rule %q", pattern)
+		}
+		return func(_ string, p int) bool { return p == port }, nil
+
+	case strings.Contains(pattern, "/"):
+		_, n, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR rule %q", pattern)
+		}
+		return func(h string, _ int) bool {
+			ip := net.ParseIP(h)
+			return ip != nil && n.Contains(ip)
+		}, nil
+
+	case net.ParseIP(pattern) != nil:
+		ip := net.ParseIP(pattern)
+		return func(h string, _ int) bool { return ip.Equal(net.ParseIP(h)) }, nil
+	}
+
+	suffix := normName(strings.TrimPrefix(pattern, "*."))
+	return func(h string, _ int) bool {
+		return h == suffix || strings.HasSuffix(h, "."+suffix)
+	}, nil
+}
+
+func splitDest(dst string) (string, int, bool) {
+	host, p, err := net.SplitHostPort(dst)
+	if err != nil {
+		return "", 0, false
+	}
+	port, _ := strconv.Atoi(p)
+	return normName(host), port, true
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// queryTCP asks srv for the qtype records of name over DNS/TCP and returns
+// them with the lowest TTL among them, so the answer can be cached as long
+// as the zone allows.
+func queryTCP(ctx context.Context, dialFn func(ctx context.Context, netw, addr string) (net.Conn, error),
+	srv, name string, qtype uint16) ([]net.IP, time.Duration, error) {
+
+	fqdn := name
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+	qname, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, 0, err
+	}
+	id := uint16(rand.Uint32())
+	query, err := (&dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  qname,
+			Type:  dnsmessage.Type(qtype),
+			Class: dnsmessage.ClassINET,
+		}},
+	}).Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	conn, err := dialFn(ctx, "tcp", srv)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	frame := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(frame, uint16(len(query)))
+	copy(frame[2:], query)
+	if _, err = conn.Write(frame); err != nil {
+		return nil, 0, err
+	}
+
+	var size [2]byte
+	if _, err = io.ReadFull(conn, size[:]); err != nil {
+		return nil, 0, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err = io.ReadFull(conn, resp); err != nil {
+		return nil, 0, err
+	}
+
+	var msg dnsmessage.Message
+	if err = msg.Unpack(resp); err != nil {
+		return nil, 0, err
+	}
+	if msg.Header.ID != id {
+		return nil, 0, fmt.Errorf("DNS id mismatch from %s", srv)
+	}
+	if msg.Header.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("DNS %s for %s from %s", msg.Header.RCode, name, srv)
+	}
+
+	var (
+		ips []net.IP
+		ttl uint32
+	)
+	for _, ans := range msg.Answers {
+		var ip net.IP
+		switch rr := ans.Body.(type) {
+		case *dnsmessage.AResource:
+			if qtype == dnsTypeA {
+				ip = net.IP(rr.A[:])
+			}
+		case *dnsmessage.AAAAResource:
+			if qtype == dnsTypeAAAA {
+				ip = net.IP(rr.AAAA[:])
+			}
+		}
+		if ip == nil {
+			continue
+		}
+		if len(ips) == 0 || ans.Header.TTL < ttl {
+			ttl = ans.Header.TTL
+		}
+		ips = append(ips, ip)
+	}
+	return ips, time.Duration(ttl) * time.Second, nil
+}
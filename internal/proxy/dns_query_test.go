@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// startTCPDNS answers every A query with 192.0.2.1 at the given TTL and
+// counts the queries it saw.
+func startTCPDNS(t *testing.T, ttl uint32) (string, *int32) {
+	t.Helper()
+	ln := listenLoopback(t)
+	var queries int32
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				var size [2]byte
+				if _, err := io.ReadFull(c, size[:]); err != nil {
+					return
+				}
+				req := make([]byte, binary.BigEndian.Uint16(size[:]))
+				if _, err := io.ReadFull(c, req); err != nil {
+					return
+				}
+				atomic.AddInt32(&queries, 1)
+
+				var msg dnsmessage.Message
+				if err := msg.Unpack(req); err != nil {
+					return
+				}
+				msg.Header.Response = true
+				q := msg.Questions[0]
+				if q.Type == dnsmessage.TypeA {
+					msg.Answers = []dnsmessage.Resource{{
+						Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl},
+						Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+					}}
+				}
+				resp, err := msg.Pack()
+				if err != nil {
+					return
+				}
+				frame := make([]byte, 2+len(resp))
+				binary.BigEndian.PutUint16(frame, uint16(len(resp)))
+				copy(frame[2:], resp)
+				_, _ = c.Write(frame)
+			}()
+		}
+	}()
+	return ln.Addr().String(), &queries
+}
+
+func TestQueryTCPReturnsTTL(t *testing.T) {
+	srv, _ := startTCPDNS(t, 42)
+	ips, ttl, err := queryTCP(context.Background(), plainDial, srv, "example.test", dnsTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("ips = %v", ips)
+	}
+	if ttl != 42*time.Second {
+		t.Fatalf("ttl = %v, want 42s", ttl)
+	}
+}
+
+func TestLookupHonoursTTL(t *testing.T) {
+	srv, queries := startTCPDNS(t, 1)
+	r := NewDNSResolver([]string{srv}, false, plainDial)
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := r.Resolve(context.Background(), "example.test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(queries); n != 1 {
+		t.Fatalf("%d queries within the TTL, want 1", n)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if _, _, err := r.Resolve(context.Background(), "example.test"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(queries); n != 2 {
+		t.Fatalf("%d queries after the TTL expired, want 2", n)
+	}
+}
+
+// The server name is re-resolved before every reconnect; a cached answer
+// would keep dialing an address the record no longer points to.
+func TestLookupBootSkipsCache(t *testing.T) {
+	srv, queries := startTCPDNS(t, 300)
+	r := NewDNSResolver([]string{srv}, false, nil)
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.LookupBoot(context.Background(), "ssh.example.test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(queries); n != 2 {
+		t.Fatalf("%d queries for 2 lookups, want 2", n)
+	}
+}
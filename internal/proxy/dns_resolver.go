@@ -7,17 +7,24 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	timeOutResolve = 3 * time.Second
+	// answers are cached for their record TTL, but never longer than this
+	dnsCacheMaxTTL = 5 * time.Minute
+
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
 )
 
 type dnsJSON struct {
 	Answer []struct {
 		Data string `json:"data"`
 		Type int    `json:"type"`
+		TTL  uint32 `json:"TTL"`
 	} `json:"Answer"`
 }
 
@@ -26,14 +33,104 @@ type DNSResolver struct {
 	dial       func(ctx context.Context, netw, addr string) (net.Conn, error)
 	v6         bool
 	httpClient *http.Client
+
+	split  []splitRoute
+	hosts  Hosts
+	remote bool
+
+	bootDial func(ctx context.Context, netw, addr string) (net.Conn, error)
+	bootHTTP *http.Client
+
+	cacheMu sync.Mutex
+	cache   map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	ips []net.IP
+	exp time.Time
+}
+
+// Flush drops every cached answer, e.g. after the host moved to another
+// network where names may resolve differently.
+func (r *DNSResolver) Flush() {
+	r.cacheMu.Lock()
+	r.cache = make(map[string]dnsEntry)
+	r.cacheMu.Unlock()
+}
+
+func (r *DNSResolver) cached(key string) ([]net.IP, bool) {
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+	e, ok := r.cache[key]
+	if !ok || time.Now().After(e.exp) {
+		delete(r.cache, key)
+		return nil, false
+	}
+	return e.ips, true
+}
+
+func (r *DNSResolver) store(key string, ips []net.IP, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	if ttl > dnsCacheMaxTTL {
+		ttl = dnsCacheMaxTTL
+	}
+	r.cacheMu.Lock()
+	r.cache[key] = dnsEntry{ips: ips, exp: time.Now().Add(ttl)}
+	r.cacheMu.Unlock()
+}
+
+// WithSplit enables per-suffix upstreams, static hosts overrides and
+// pass-through of hostnames to the SSH server (remote resolution).
+func (r *DNSResolver) WithSplit(split map[string][]string, hosts Hosts, remote bool) *DNSResolver {
+	r.split = newSplitRoutes(split)
+	r.hosts = hosts
+	r.remote = remote
+	return r
+}
+
+// WithBootDial routes bootstrap lookups through dial (e.g. an upstream proxy)
+// instead of the direct network.
+func (r *DNSResolver) WithBootDial(dial func(ctx context.Context, netw, addr string) (net.Conn, error)) *DNSResolver {
+	if dial == nil {
+		return r
+	}
+	r.bootDial = dial
+	r.bootHTTP = &http.Client{
+		Transport: &http.Transport{DialContext: dial, ForceAttemptHTTP2: true},
+		Timeout:   timeOutResolve,
+	}
+	return r
 }
 
 func (r *DNSResolver) ResolveBoot(parent context.Context, name string) (context.Context, net.IP, error) {
-	return r.resolveInternal(parent, name, plainDial, http.DefaultClient)
+	if ip, ok := r.hosts.Lookup(name); ok {
+		return parent, ip, nil
+	}
+	if r.bootDial != nil {
+		return r.resolveInternal(parent, name, r.servers, r.bootDial, r.bootHTTP)
+	}
+	return r.resolveInternal(parent, name, r.servers, plainDial, http.DefaultClient)
 }
 
+// Resolve returns a nil IP without error when the name must be resolved by
+// the SSH server; socks5 then dials the FQDN through direct-tcpip.
 func (r *DNSResolver) Resolve(parent context.Context, name string) (context.Context, net.IP, error) {
-	return r.resolveInternal(parent, name, r.dial, r.httpClient)
+	if ip, ok := r.hosts.Lookup(name); ok {
+		return parent, ip, nil
+	}
+
+	servers := r.servers
+	if routed, ok := matchSplit(r.split, name); ok {
+		if isRemote(routed) {
+			return parent, nil, nil
+		}
+		servers = routed
+	} else if r.remote {
+		return parent, nil, nil
+	}
+	return r.resolveInternal(parent, name, servers, r.dial, r.httpClient)
 }
 
 func plainDial(ctx context.Context, netw, addr string) (net.Conn, error) {
@@ -41,81 +138,161 @@ func plainDial(ctx context.Context, netw, addr string) (net.Conn, error) {
 	return d.DialContext(ctx, netw, addr)
 }
 
-func (r *DNSResolver) resolveInternal(parent context.Context, name string,
+func (r *DNSResolver) resolveInternal(parent context.Context, name string, servers []string,
 	dialFn func(ctx context.Context, netw, addr string) (net.Conn, error),
 	httpCl *http.Client) (context.Context, net.IP, error) {
 
-	wantType, wantNet := "A", "ip4"
+	types := []uint16{dnsTypeA}
+	if r.v6 {
+		types = []uint16{dnsTypeAAAA, dnsTypeA}
+	}
+	ips, err := r.lookupAll(parent, name, servers, types, dialFn, httpCl)
+	if err != nil {
+		return parent, nil, err
+	}
+	return parent, ips[0], nil
+}
+
+// LookupBoot returns every bootstrap address of name (A, plus AAAA with
+// DNSv6) so the caller can try them in turn. It always asks the servers:
+// it runs before each reconnect to pick up a moved server record.
+func (r *DNSResolver) LookupBoot(ctx context.Context, name string) ([]net.IP, error) {
+	if ip, ok := r.hosts.Lookup(name); ok {
+		return []net.IP{ip}, nil
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	types := []uint16{dnsTypeA}
 	if r.v6 {
-		wantType, wantNet = "AAAA", "ip"
+		types = append(types, dnsTypeAAAA)
 	}
+	var (
+		ips []net.IP
+		err error
+	)
+	if r.bootDial != nil {
+		ips, _, err = r.query(ctx, name, r.servers, types, r.bootDial, r.bootHTTP)
+	} else {
+		ips, _, err = r.query(ctx, name, r.servers, types, plainDial, http.DefaultClient)
+	}
+	return ips, err
+}
+
+func (r *DNSResolver) lookupAll(parent context.Context, name string, servers []string, types []uint16,
+	dialFn func(ctx context.Context, netw, addr string) (net.Conn, error),
+	httpCl *http.Client) ([]net.IP, error) {
+
+	key := fmt.Sprint(types, name)
+	if ips, ok := r.cached(key); ok {
+		return ips, nil
+	}
+
+	ips, ttl, err := r.query(parent, name, servers, types, dialFn, httpCl)
+	if err != nil {
+		return nil, err
+	}
+	r.store(key, ips, ttl)
+	return ips, nil
+}
+
+// query asks servers in turn, bypassing the cache, and returns the first
+// answer with its lowest record TTL.
+func (r *DNSResolver) query(parent context.Context, name string, servers []string, types []uint16,
+	dialFn func(ctx context.Context, netw, addr string) (net.Conn, error),
+	httpCl *http.Client) ([]net.IP, time.Duration, error) {
 
 	ctx, cancel := context.WithTimeout(parent, timeOutResolve)
 	defer cancel()
 
 	var lastErr error
 
-	for _, srv := range r.servers {
-		ip, err := func() (net.IP, error) {
+	for _, srv := range servers {
+		ips, ttl, err := func() ([]net.IP, time.Duration, error) {
 			childCtx, cancelChild := context.WithTimeout(ctx, timeOutResolve)
 			defer cancelChild()
 
-			if strings.HasPrefix(srv, "https://") {
-				url := fmt.Sprintf("%s?name=%s&type=%s", srv, name, wantType)
-
-				req, errReq := http.NewRequestWithContext(childCtx, http.MethodGet, url, nil)
-				if errReq != nil {
-					return nil, errReq
+			var (
+				ips []net.IP
+				ttl = dnsCacheMaxTTL
+			)
+			for _, t := range types {
+				var (
+					got    []net.IP
+					gotTTL time.Duration
+					err    error
+				)
+				if strings.HasPrefix(srv, "https://") {
+					got, gotTTL, err = queryDoH(childCtx, httpCl, srv, name, t)
+				} else {
+					got, gotTTL, err = queryTCP(childCtx, dialFn, srv, name, t)
 				}
-				req.Header.Set("Accept", "application/dns-json, application/dns-message")
-
-				resp, err := httpCl.Do(req)
 				if err != nil {
-					return nil, err
+					return nil, 0, err
 				}
-				defer resp.Body.Close()
-
-				if resp.StatusCode != http.StatusOK {
-					return nil, fmt.Errorf("DoH non-OK %d from %s", resp.StatusCode, srv)
+				if len(got) > 0 && gotTTL < ttl {
+					ttl = gotTTL
 				}
-
-				var dj dnsJSON
-				if err = json.NewDecoder(resp.Body).Decode(&dj); err != nil {
-					return nil, err
-				}
-				for _, ans := range dj.Answer {
-					if (ans.Type == 1 && !r.v6) || (ans.Type == 28 && r.v6) {
-						if ip := net.ParseIP(ans.Data); ip != nil {
-							return ip, nil
-						}
-					}
-				}
-				return nil, fmt.Errorf("no %s record in DoH response from %s", wantType, srv)
-			}
-
-			res := &net.Resolver{
-				PreferGo: true,
-				Dial: func(c context.Context, _, _ string) (net.Conn, error) {
-					return dialFn(c, "tcp", srv)
-				},
-			}
-			ips, err := res.LookupIP(childCtx, wantNet, name)
-			if err != nil {
-				return nil, err
+				ips = append(ips, got...)
 			}
 			if len(ips) == 0 {
-				return nil, fmt.Errorf("no %s record from %s", wantType, srv)
+				return nil, 0, fmt.Errorf("no %v record for %s from %s", types, name, srv)
 			}
-			return ips[0], nil
+			return ips, ttl, nil
 		}()
 
 		if err == nil {
-			return ctx, ip, nil
+			return ips, ttl, nil
 		}
 		lastErr = err
 	}
 
-	return ctx, nil, lastErr
+	return nil, 0, lastErr
+}
+
+func queryDoH(ctx context.Context, httpCl *http.Client, srv, name string, qtype uint16) ([]net.IP, time.Duration, error) {
+	wantType := "A"
+	if qtype == dnsTypeAAAA {
+		wantType = "AAAA"
+	}
+	url := fmt.Sprintf("%s?name=%s&type=%s", srv, name, wantType)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/dns-json, application/dns-message")
+
+	resp, err := httpCl.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("DoH non-OK %d from %s", resp.StatusCode, srv)
+	}
+
+	var dj dnsJSON
+	if err = json.NewDecoder(resp.Body).Decode(&dj); err != nil {
+		return nil, 0, err
+	}
+	var (
+		ips []net.IP
+		ttl uint32
+	)
+	for _, ans := range dj.Answer {
+		if uint16(ans.Type) == qtype {
+			if ip := net.ParseIP(ans.Data); ip != nil {
+				if len(ips) == 0 || ans.TTL < ttl {
+					ttl = ans.TTL
+				}
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips, time.Duration(ttl) * time.Second, nil
 }
 
 func NewDNSResolver(servers []string, v6 bool, dial func(ctx context.Context, netw, addr string) (net.Conn, error)) *DNSResolver {
@@ -131,5 +308,6 @@ func NewDNSResolver(servers []string, v6 bool, dial func(ctx context.Context, ne
 		dial:       dial,
 		v6:         v6,
 		httpClient: &http.Client{Transport: tr, Timeout: timeOutResolve},
+		cache:      make(map[string]dnsEntry),
 	}
 }
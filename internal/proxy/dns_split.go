@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+const remoteUpstream = "remote"
+
+type Hosts map[string]net.IP
+
+func LoadHosts(entries []string, path string) (Hosts, error) {
+	h := make(Hosts)
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line, _, _ := strings.Cut(sc.Text(), "#")
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			ip := net.ParseIP(fields[0])
+			if ip == nil {
+				continue
+			}
+			for _, name := range fields[1:] {
+				h[normName(name)] = ip
+			}
+		}
+		if err = sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, e := range entries {
+		name, addr, ok := strings.Cut(e, "=")
+		ip := net.ParseIP(strings.TrimSpace(addr))
+		if !ok || ip == nil {
+			return nil, fmt.Errorf("invalid hosts entry %q", e)
+		}
+		h[normName(name)] = ip
+	}
+	return h, nil
+}
+
+func (h Hosts) Lookup(name string) (net.IP, bool) {
+	ip, ok := h[normName(name)]
+	return ip, ok
+}
+
+type splitRoute struct {
+	suffix  string
+	servers []string
+}
+
+func newSplitRoutes(rules map[string][]string) []splitRoute {
+	routes := make([]splitRoute, 0, len(rules))
+	for suffix, servers := range rules {
+		routes = append(routes, splitRoute{suffix: normName(suffix), servers: servers})
+	}
+	// longest suffix wins, so "a.corp.internal" beats "corp.internal"
+	sort.Slice(routes, func(i, j int) bool {
+		return len(routes[i].suffix) > len(routes[j].suffix)
+	})
+	return routes
+}
+
+func matchSplit(routes []splitRoute, name string) ([]string, bool) {
+	name = normName(name)
+	for _, rt := range routes {
+		if name == rt.suffix || strings.HasSuffix(name, "."+rt.suffix) {
+			return rt.servers, true
+		}
+	}
+	return nil, false
+}
+
+func isRemote(servers []string) bool {
+	return len(servers) == 1 && servers[0] == remoteUpstream
+}
+
+func normName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
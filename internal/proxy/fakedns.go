@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"net"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/handoff"
+)
+
+const (
+	fakeDNSTTL     = 1
+	maxDNSPacketSz = 1232
+)
+
+type FakeDNSServer struct {
+	pool  *FakeIPPool
+	hosts Hosts
+	pc    net.PacketConn
+}
+
+func NewFakeDNS(listen string, pool *FakeIPPool, hosts Hosts) (*FakeDNSServer, error) {
+	pc, err := handoff.ListenPacket("udp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FakeDNSServer{pool: pool, hosts: hosts, pc: pc}
+	go func() {
+		zap.L().Info("fake-ip DNS listening on", zap.String("listen", listen))
+		s.serve()
+	}()
+	return s, nil
+}
+
+func (s *FakeDNSServer) Close() error {
+	return s.pc.Close()
+}
+
+func (s *FakeDNSServer) serve() {
+	buf := make([]byte, maxDNSPacketSz)
+	for {
+		n, from, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		resp, err := s.answer(buf[:n])
+		if err != nil {
+			zap.L().Debug("fake_dns_bad_query", zap.String("from", from.String()), zap.Error(err))
+			continue
+		}
+		_, _ = s.pc.WriteTo(resp, from)
+	}
+}
+
+func (s *FakeDNSServer) answer(query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	rcode := dnsmessage.RCodeSuccess
+	var ip net.IP
+
+	switch q.Type {
+	case dnsmessage.TypeA:
+		name := q.Name.String()
+		if pinned, ok := s.hosts.Lookup(name); ok && pinned.To4() != nil {
+			ip = pinned.To4()
+		} else if ip, err = s.pool.Lookup(name); err != nil {
+			zap.L().Warn("fake_dns_alloc_failed", zap.String("name", name), zap.Error(err))
+			rcode = dnsmessage.RCodeServerFailure
+		}
+	case dnsmessage.TypeAAAA:
+		// empty answer pushes clients to IPv4, which is what the pool serves
+	default:
+		rcode = dnsmessage.RCodeNotImplemented
+	}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, maxDNSPacketSz), dnsmessage.Header{
+		ID:                 hdr.ID,
+		Response:           true,
+		RecursionDesired:   hdr.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              rcode,
+	})
+	b.EnableCompression()
+	if err = b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err = b.Question(q); err != nil {
+		return nil, err
+	}
+	if err = b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if ip != nil {
+		var a dnsmessage.AResource
+		copy(a.A[:], ip)
+		err = b.AResource(dnsmessage.ResourceHeader{
+			Name:  q.Name,
+			Class: dnsmessage.ClassINET,
+			TTL:   fakeDNSTTL,
+		}, a)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const periodFakeIPGC = 30 * time.Second
+
+type fakeEntry struct {
+	name    string
+	ip      uint32
+	expires time.Time
+}
+
+// FakeIPPool hands out addresses from a reserved range so that the hostname
+// can be recovered when a client later connects to the fake address.
+type FakeIPPool struct {
+	ttl   time.Duration
+	first uint32
+	size  uint32
+	ipNet *net.IPNet
+
+	mu     sync.Mutex
+	next   uint32
+	byName map[string]*fakeEntry
+	byIP   map[uint32]*fakeEntry
+}
+
+func NewFakeIPPool(cidr string, ttl time.Duration) (*FakeIPPool, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	if ipNet.IP.To4() == nil {
+		return nil, errors.New("fake-ip: only IPv4 ranges are supported")
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits-ones < 2 {
+		return nil, errors.New("fake-ip: range too small")
+	}
+
+	p := &FakeIPPool{
+		ttl:   ttl,
+		ipNet: ipNet,
+		// skip network address, keep broadcast out of the pool
+		first:  binary.BigEndian.Uint32(ipNet.IP.To4()) + 1,
+		size:   uint32(1)<<uint(bits-ones) - 2,
+		byName: make(map[string]*fakeEntry),
+		byIP:   make(map[uint32]*fakeEntry),
+	}
+	go p.gc()
+	return p, nil
+}
+
+func (p *FakeIPPool) Lookup(name string) (net.IP, error) {
+	name = normName(name)
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e, ok := p.byName[name]; ok {
+		e.expires = now.Add(p.ttl)
+		return toIP(e.ip), nil
+	}
+
+	for i := uint32(0); i < p.size; i++ {
+		ip := p.first + (p.next+i)%p.size
+		if old, busy := p.byIP[ip]; busy {
+			if now.Before(old.expires) {
+				continue
+			}
+			delete(p.byName, old.name)
+		}
+		e := &fakeEntry{name: name, ip: ip, expires: now.Add(p.ttl)}
+		p.byIP[ip] = e
+		p.byName[name] = e
+		p.next = (p.next + i + 1) % p.size
+		return toIP(ip), nil
+	}
+	return nil, errors.New("fake-ip: pool exhausted")
+}
+
+// Reverse returns the hostname behind a fake address and refreshes its lease.
+func (p *FakeIPPool) Reverse(ip net.IP) (string, bool) {
+	if p == nil || !p.ipNet.Contains(ip) {
+		return "", false
+	}
+	v4 := ip.To4()
+	if v4 == nil {
+		return "", false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.byIP[binary.BigEndian.Uint32(v4)]
+	if !ok {
+		return "", false
+	}
+	e.expires = time.Now().Add(p.ttl)
+	return e.name, true
+}
+
+// ReverseAddr rewrites "fakeip:port" into "hostname:port".
+func (p *FakeIPPool) ReverseAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if name, ok := p.Reverse(net.ParseIP(host)); ok {
+		return net.JoinHostPort(name, port)
+	}
+	return addr
+}
+
+func (p *FakeIPPool) gc() {
+	t := time.NewTicker(periodFakeIPGC)
+	defer t.Stop()
+	for range t.C {
+		now := time.Now()
+		p.mu.Lock()
+		for ip, e := range p.byIP {
+			if now.After(e.expires) {
+				delete(p.byIP, ip)
+				delete(p.byName, e.name)
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+func toIP(v uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, v)
+	return ip
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/handoff"
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/metrics"
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/sshclient"
+)
+
+const (
+	forwardDialAttempts = 3
+	forwardDialBackoff  = 1 * time.Second
+	unixPrefix          = "unix:"
+)
+
+// Forward is a static -L style tunnel: every connection accepted on the
+// local endpoint is piped to a fixed target through the SSH upstream.
+type Forward struct {
+	local  string
+	target string
+	ln     net.Listener
+	dial   sshclient.DialFunc
+	stats  *metrics.ForwardStats
+}
+
+func NewForward(local, target string, dial sshclient.DialFunc) (*Forward, error) {
+	network, addr := "tcp", local
+	if strings.HasPrefix(local, unixPrefix) {
+		network, addr = "unix", strings.TrimPrefix(local, unixPrefix)
+		// a socket left over from a previous run blocks the bind; one handed
+		// over by the previous process is still in use
+		if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 && !handoff.Inherited(network, addr) {
+			_ = os.Remove(addr)
+		}
+	}
+
+	ln, err := listenNet(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Forward{
+		local:  local,
+		target: target,
+		ln:     ln,
+		dial:   dial,
+		stats:  metrics.NewForwardStats(local + "->" + target),
+	}
+	go f.serve()
+	return f, nil
+}
+
+func (f *Forward) Shutdown(_ context.Context) error {
+	return f.ln.Close()
+}
+
+func (f *Forward) serve() {
+	zap.L().Info("forward listening on", zap.String("local", f.local), zap.String("target", f.target))
+	for {
+		c, err := f.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			zap.L().Warn("forward_accept_err", zap.String("local", f.local), zap.Error(err))
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go f.handle(c)
+	}
+}
+
+func (f *Forward) handle(c net.Conn) {
+	up, err := f.dialRetry(WithConnInfo(context.Background(), ListenerForward, c.RemoteAddr().String()))
+	if err != nil {
+		f.stats.Failed()
+		zap.L().Warn("forward_dial_failed",
+			zap.String("local", f.local),
+			zap.String("target", f.target),
+			zap.String("client", c.RemoteAddr().String()),
+			zap.Error(err),
+		)
+		_ = c.Close()
+		return
+	}
+
+	f.stats.Opened()
+	defer f.stats.Closed()
+
+	zap.L().Debug("forward_open", zap.String("local", f.local), zap.String("target", f.target),
+		zap.String("client", c.RemoteAddr().String()))
+	copyBoth(f.stats.Wrap(up), c)
+}
+
+// dialRetry rides out short SSH reconnects instead of failing the client
+// on the first error.
+func (f *Forward) dialRetry(ctx context.Context) (net.Conn, error) {
+	var lastErr error
+	backoff := forwardDialBackoff
+	for attempt := 0; attempt < forwardDialAttempts; attempt++ {
+		conn, err := f.dial(ctx, "tcp", f.target)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if errors.Is(err, ErrQuotaExceeded) {
+			break
+		}
+		zap.L().Debug("forward_dial_retry", zap.String("target", f.target), zap.Int("attempt", attempt+1), zap.Error(err))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return nil, lastErr
+}
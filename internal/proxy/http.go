@@ -1,19 +1,17 @@
 package proxy
 
 import (
+	"errors"
 	"io"
 	"net"
 	"net/http"
-	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/sshclient"
 )
 
-const (
-	lifeMax = 60 * time.Minute
-)
+const retryAfterUpstreamDown = "5"
 
 func NewHTTP(listen string, dial sshclient.DialFunc) *http.Server {
 	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -21,8 +19,17 @@ func NewHTTP(listen string, dial sshclient.DialFunc) *http.Server {
 			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
 			return
 		}
-		dst, err := dial(r.Context(), "tcp", r.Host)
+		host, _, _ := net.SplitHostPort(r.Host)
+		ctx := WithConnInfoOf(r.Context(), ConnInfo{Listener: ListenerHTTP, ClientAddr: r.RemoteAddr, Host: host})
+		dst, err := dial(ctx, "tcp", r.Host)
 		if err != nil {
+			if errors.Is(err, ErrQuotaExceeded) {
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			if errors.Is(err, sshclient.ErrUpstreamDown) {
+				w.Header().Set("Retry-After", retryAfterUpstreamDown)
+			}
 			http.Error(w, err.Error(), http.StatusServiceUnavailable)
 			return
 		}
@@ -32,24 +39,15 @@ func NewHTTP(listen string, dial sshclient.DialFunc) *http.Server {
 		go copyBoth(dst, src)
 	})
 	srv := &http.Server{Addr: listen, Handler: h}
+	// bind before returning so an inherited socket is claimed ahead of handoff.Ready
+	ln, err := listenTCP(listen)
+	if err != nil {
+		zap.L().Error("HTTP proxy listen", zap.String("listen", listen), zap.Error(err))
+		return srv
+	}
 	go func() {
 		zap.L().Info("HTTP proxy listening on", zap.String("listen", listen))
-		_ = srv.ListenAndServe()
+		_ = srv.Serve(ln)
 	}()
 	return srv
 }
-
-func copyBoth(a, b net.Conn) {
-	defer func() { _ = a.Close() }()
-	defer func() { _ = b.Close() }()
-
-	done := make(chan struct{}, 2)
-
-	go func() { io.Copy(a, b); done <- struct{}{} }()
-	go func() { io.Copy(b, a); done <- struct{}{} }()
-
-	select {
-	case <-done:
-	case <-time.After(lifeMax):
-	}
-}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"net"
+	"time"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/handoff"
+)
+
+const (
+	defaultClientKeepAlive = 30 * time.Second
+	clientKeepAliveCount   = 3
+)
+
+var clientKeepAlive = keepAliveConfig(defaultClientKeepAlive)
+
+// SetClientKeepAlive sets the TCP keepalive probe interval on accepted
+// client connections, so a vanished client is noticed while its tunnel is
+// otherwise quiet; 0 turns the probes off. Call it before starting listeners.
+func SetClientKeepAlive(d time.Duration) {
+	clientKeepAlive = keepAliveConfig(d)
+}
+
+func keepAliveConfig(d time.Duration) net.KeepAliveConfig {
+	if d <= 0 {
+		return net.KeepAliveConfig{}
+	}
+	return net.KeepAliveConfig{Enable: true, Idle: d, Interval: d, Count: clientKeepAliveCount}
+}
+
+func listenConfig() net.ListenConfig {
+	lc := net.ListenConfig{KeepAliveConfig: clientKeepAlive}
+	if !clientKeepAlive.Enable {
+		lc.KeepAlive = -1
+	}
+	return lc
+}
+
+func listenTCP(addr string) (net.Listener, error) {
+	return listenNet("tcp", addr)
+}
+
+func listenNet(network, addr string) (net.Listener, error) {
+	return handoff.Listen(listenConfig(), network, addr)
+}
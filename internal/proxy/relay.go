@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/metrics"
+)
+
+const relayBufSize = 32 << 10
+
+var relayBufs = sync.Pool{New: func() any {
+	b := make([]byte, relayBufSize)
+	return &b
+}}
+
+// copyBoth relays a <-> b until both directions finished. An EOF on one side
+// is passed on as a half-close, so protocols that shut down their write side
+// first still get the reply. Idle and lifetime limits belong to the conns.
+func copyBoth(a, b net.Conn) {
+	defer func() { _ = a.Close() }()
+	defer func() { _ = b.Close() }()
+
+	done := make(chan struct{}, 2)
+	go func() { relay(a, b); done <- struct{}{} }()
+	go func() { relay(b, a); done <- struct{}{} }()
+	<-done
+	<-done
+}
+
+// relay copies src to dst with a pooled buffer. On a clean EOF dst is
+// half-closed; on an error, or when dst cannot half-close, both sides are
+// closed so the opposite direction does not hang.
+func relay(dst, src net.Conn) {
+	bp := relayBufs.Get().(*[]byte)
+	_, err := io.CopyBuffer(dst, src, *bp)
+	relayBufs.Put(bp)
+
+	if err == nil && metrics.CloseWrite(dst) == nil {
+		return
+	}
+	_ = dst.Close()
+	_ = src.Close()
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+const benchPayload = 1 << 20
+
+// copyBothIOCopy is the relay as it was before pooled buffers: two io.Copy
+// calls, and both sides closed as soon as either direction ends.
+func copyBothIOCopy(a, b net.Conn) {
+	defer func() { _ = a.Close() }()
+	defer func() { _ = b.Close() }()
+
+	done := make(chan struct{}, 2)
+	go func() { _, _ = io.Copy(a, b); done <- struct{}{} }()
+	go func() { _, _ = io.Copy(b, a); done <- struct{}{} }()
+	<-done
+}
+
+// benchRelay pushes benchPayload bytes per connection through relayFn:
+// the client writes and half-closes, the server reads to EOF and closes.
+func benchRelay(b *testing.B, relayFn func(a, b net.Conn)) {
+	ln := listenLoopback(b)
+	payload := make([]byte, benchPayload)
+
+	b.SetBytes(benchPayload)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client, proxyIn := tcpPair(b, ln)
+		proxyOut, server := tcpPair(b, ln)
+
+		go relayFn(wrapConn{proxyIn}, wrapConn{proxyOut})
+		go func() {
+			_, _ = io.Copy(io.Discard, server)
+			_ = server.Close()
+		}()
+
+		if _, err := client.Write(payload); err != nil {
+			b.Fatal(err)
+		}
+		_ = client.(*net.TCPConn).CloseWrite()
+		_, _ = io.Copy(io.Discard, client)
+		_ = client.Close()
+	}
+}
+
+func BenchmarkCopyBoth(b *testing.B)       { benchRelay(b, copyBoth) }
+func BenchmarkCopyBothIOCopy(b *testing.B) { benchRelay(b, copyBothIOCopy) }
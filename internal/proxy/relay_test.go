@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/metrics"
+)
+
+// wrapConn hides ReadFrom/WriteTo like the proxy's own wrappers do, so
+// copies go through the relay buffer rather than splice.
+type wrapConn struct{ net.Conn }
+
+func (c wrapConn) CloseWrite() error { return metrics.CloseWrite(c.Conn) }
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(tb testing.TB, ln net.Listener) (net.Conn, net.Conn) {
+	tb.Helper()
+	acc := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			acc <- nil
+			return
+		}
+		acc <- c
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	s := <-acc
+	if s == nil {
+		tb.Fatal("accept failed")
+	}
+	return c, s
+}
+
+func listenLoopback(tb testing.TB) net.Listener {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = ln.Close() })
+	return ln
+}
+
+// TestCopyBothHalfClose sends a request, half-closes the client side and
+// expects the server's reply to make it back through the relay.
+func TestCopyBothHalfClose(t *testing.T) {
+	ln := listenLoopback(t)
+	client, proxyIn := tcpPair(t, ln)
+	proxyOut, server := tcpPair(t, ln)
+	defer client.Close()
+	defer server.Close()
+
+	go copyBoth(wrapConn{proxyIn}, wrapConn{proxyOut})
+
+	go func() {
+		req, _ := io.ReadAll(server)
+		_, _ = server.Write(append([]byte("got:"), req...))
+		_ = server.Close()
+	}()
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "got:ping" {
+		t.Fatalf("reply = %q, want %q", reply, "got:ping")
+	}
+}
+
+// When the client half-closes first the access log blames the client, even
+// though the upstream's EOF follows once it has replied.
+func TestCopyBothClientCloseReason(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	access := NewAccessLog(zap.New(core))
+
+	ln := listenLoopback(t)
+	client, proxyIn := tcpPair(t, ln)
+	proxyOut, server := tcpPair(t, ln)
+	defer client.Close()
+	defer server.Close()
+
+	upstream := access.Wrap(wrapConn{proxyOut}, AccessRecord{Start: time.Now()})
+	done := make(chan struct{})
+	go func() {
+		copyBoth(upstream, wrapConn{proxyIn})
+		close(done)
+	}()
+
+	go func() {
+		_, _ = io.ReadAll(server)
+		_, _ = server.Write([]byte("pong"))
+		_ = server.Close()
+	}()
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.(*net.TCPConn).CloseWrite()
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if reply, _ := io.ReadAll(client); string(reply) != "pong" {
+		t.Fatalf("reply = %q, want %q", reply, "pong")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay did not finish")
+	}
+	entries := logs.FilterMessage("access").All()
+	if len(entries) != 1 {
+		t.Fatalf("%d access records, want 1", len(entries))
+	}
+	if got := entries[0].ContextMap()["close_reason"]; got != CloseClient {
+		t.Fatalf("close_reason = %v, want %q", got, CloseClient)
+	}
+}
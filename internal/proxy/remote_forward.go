@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/metrics"
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/sshclient"
+)
+
+const (
+	remoteListenAttempts = 5
+	remoteListenBackoff  = 1 * time.Second
+	localDialTimeout     = 5 * time.Second
+)
+
+// RemoteForward is a -R style tunnel: the SSH server listens on remote and
+// every incoming connection is piped to local on this host.
+type RemoteForward struct {
+	local  string
+	stats  *metrics.ForwardStats
+	bind   *remoteBinding
+	policy *ConnPolicy
+}
+
+func NewRemoteForward(r *sshclient.Reconnector, remote, local string, policy *ConnPolicy) *RemoteForward {
+	f := &RemoteForward{
+		local:  local,
+		stats:  metrics.NewForwardStats("R:" + remote + "->" + local),
+		policy: policy,
+	}
+	f.bind = &remoteBinding{rec: r, addr: remote, serve: f.serve}
+	r.OnConnect(f.bind.attach)
+	return f
+}
+
+func (f *RemoteForward) Shutdown(_ context.Context) error {
+	return f.bind.close()
+}
+
+func (f *RemoteForward) serve(ln net.Listener) {
+	zap.L().Info("remote forward listening on", zap.String("remote", f.bind.addr), zap.String("local", f.local))
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			zap.L().Debug("remote_forward_accept_stop", zap.String("remote", f.bind.addr), zap.Error(err))
+			return
+		}
+		go f.handle(c)
+	}
+}
+
+func (f *RemoteForward) handle(c net.Conn) {
+	network, addr := "tcp", f.local
+	if strings.HasPrefix(addr, unixPrefix) {
+		network, addr = "unix", strings.TrimPrefix(addr, unixPrefix)
+	}
+
+	dst, err := net.DialTimeout(network, addr, localDialTimeout)
+	if err != nil {
+		f.stats.Failed()
+		zap.L().Warn("remote_forward_dial_failed", zap.String("local", f.local), zap.Error(err))
+		_ = c.Close()
+		return
+	}
+
+	f.stats.Opened()
+	defer f.stats.Closed()
+	lifetime, idle := f.policy.Limits(f.local)
+	copyBoth(f.stats.Wrap(c), metrics.NewIdleConn(dst, idle, lifetime))
+}
+
+// remoteBinding keeps one server-side listener alive across SSH clients.
+type remoteBinding struct {
+	rec   *sshclient.Reconnector
+	addr  string
+	serve func(ln net.Listener)
+
+	mu     sync.Mutex
+	ln     net.Listener
+	closed bool
+}
+
+// attach (re)creates the listener on a fresh SSH client. The previous
+// client may still be draining, so its listener lets go of the port first;
+// tunnels it accepted stay open. The server may still hold the port of a
+// dead session for a moment, hence the retries.
+func (b *remoteBinding) attach(cl *ssh.Client) {
+	b.mu.Lock()
+	if b.ln != nil {
+		_ = b.ln.Close()
+		b.ln = nil
+	}
+	b.mu.Unlock()
+
+	ln, err := listenRemote(b.rec, cl, b.addr)
+	if err != nil {
+		zap.L().Error("remote_listen_failed", zap.String("remote", b.addr), zap.Error(err))
+		return
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		_ = ln.Close()
+		return
+	}
+	if b.ln != nil {
+		_ = b.ln.Close()
+	}
+	b.ln = ln
+	b.mu.Unlock()
+
+	// tracked so shutdown drains these tunnels before closing the SSH client
+	b.serve(metrics.NewTrackListener(ln))
+}
+
+func (b *remoteBinding) close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	if b.ln != nil {
+		return b.ln.Close()
+	}
+	return nil
+}
+
+func listenRemote(r *sshclient.Reconnector, cl *ssh.Client, addr string) (net.Listener, error) {
+	var lastErr error
+	backoff := remoteListenBackoff
+	for attempt := 0; attempt < remoteListenAttempts; attempt++ {
+		ln, err := r.Listen(cl, "tcp", addr)
+		if err == nil {
+			return ln, nil
+		}
+		lastErr = err
+		zap.L().Debug("remote_listen_retry", zap.String("remote", addr), zap.Int("attempt", attempt+1), zap.Error(err))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return nil, lastErr
+}
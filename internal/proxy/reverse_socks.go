@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/armon/go-socks5"
+	"go.uber.org/zap"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/config"
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/metrics"
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/sshclient"
+)
+
+// ReverseSocks serves SOCKS5 on a listener owned by the SSH server and dials
+// targets from this host, exposing our local network to the server side.
+type ReverseSocks struct {
+	srv  *socks5.Server
+	bind *remoteBinding
+}
+
+func NewReverseSOCKS(cfg *config.Config, r *sshclient.Reconnector, policy *ConnPolicy) (*ReverseSocks, error) {
+	rules, err := newReverseRules(cfg.ReverseSocksAllowSrc, cfg.ReverseSocksAllowDst, cfg.ReverseSocksAllowPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &socks5.Config{
+		Rules: rules,
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d := net.Dialer{Timeout: localDialTimeout}
+			conn, err := d.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			lifetime, idle := policy.Limits(addr)
+			return metrics.NewIdleConn(conn, idle, lifetime), nil
+		},
+		Logger: log.New(io.Discard, "", 0),
+	}
+	if cfg.ReverseSocksUser != "" {
+		sc.Credentials = socks5.StaticCredentials{cfg.ReverseSocksUser: cfg.ReverseSocksPass}
+	}
+
+	srv, err := socks5.New(sc)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &ReverseSocks{srv: srv}
+	rs.bind = &remoteBinding{rec: r, addr: cfg.ReverseSocksL, serve: rs.serve}
+	r.OnConnect(rs.bind.attach)
+	return rs, nil
+}
+
+func (s *ReverseSocks) Shutdown(_ context.Context) error {
+	return s.bind.close()
+}
+
+func (s *ReverseSocks) serve(ln net.Listener) {
+	zap.L().Info("reverse SOCKS listening on server side", zap.String("remote", s.bind.addr))
+	if err := s.srv.Serve(ln); err != nil {
+		zap.L().Debug("reverse_socks_serve_stop", zap.String("remote", s.bind.addr), zap.Error(err))
+	}
+}
+
+type reverseRules struct {
+	srcNets []*net.IPNet
+	dstNets []*net.IPNet
+	dstHost []string
+	ports   map[int]bool
+}
+
+func newReverseRules(src, dst, ports []string) (*reverseRules, error) {
+	rr := &reverseRules{ports: make(map[int]bool)}
+
+	for _, s := range src {
+		_, n, err := net.ParseCIDR(withMask(s))
+		if err != nil {
+			return nil, err
+		}
+		rr.srcNets = append(rr.srcNets, n)
+	}
+
+	for _, d := range dst {
+		if _, n, err := net.ParseCIDR(withMask(d)); err == nil {
+			rr.dstNets = append(rr.dstNets, n)
+			continue
+		}
+		rr.dstHost = append(rr.dstHost, normName(strings.TrimPrefix(d, "*.")))
+	}
+
+	for _, p := range ports {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, err
+		}
+		rr.ports[n] = true
+	}
+	return rr, nil
+}
+
+// Allow only permits CONNECT. With an empty destination allow-list
+// nothing is reachable: exposing the whole office LAN must be explicit.
+func (rr *reverseRules) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	if req.Command != socks5.ConnectCommand {
+		return ctx, false
+	}
+
+	if len(rr.srcNets) > 0 && (req.RemoteAddr == nil || !containsIP(rr.srcNets, req.RemoteAddr.IP)) {
+		zap.L().Warn("reverse_socks_src_denied", zap.Any("client", req.RemoteAddr))
+		return ctx, false
+	}
+
+	dst := req.DestAddr
+	if len(rr.ports) > 0 && !rr.ports[dst.Port] {
+		zap.L().Warn("reverse_socks_port_denied", zap.String("dst", dst.String()))
+		return ctx, false
+	}
+
+	if dst.FQDN != "" {
+		name := normName(dst.FQDN)
+		for _, h := range rr.dstHost {
+			if name == h || strings.HasSuffix(name, "."+h) {
+				return ctx, true
+			}
+		}
+	}
+	if dst.IP != nil && containsIP(rr.dstNets, dst.IP) {
+		return ctx, true
+	}
+
+	zap.L().Warn("reverse_socks_dst_denied", zap.String("dst", dst.String()))
+	return ctx, false
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func withMask(s string) string {
+	if strings.Contains(s, "/") {
+		return s
+	}
+	if ip := net.ParseIP(s); ip != nil && ip.To4() == nil {
+		return s + "/128"
+	}
+	return s + "/32"
+}
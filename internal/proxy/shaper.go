@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/config"
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/metrics"
+)
+
+// clientBucketIdle is how long a client's bucket outlives its last
+// connection; by then it has refilled, so a fresh one behaves the same.
+const clientBucketIdle = 5 * time.Minute
+
+// ErrQuotaExceeded says "connection refused" so go-socks5 answers 0x05.
+var ErrQuotaExceeded = errors.New("daily quota exceeded: connection refused")
+
+// Shaper rate-limits upstream connections at four levels: global, per
+// listener, per client IP and per destination rule. Every level is a token
+// bucket over bytes in both directions; a connection waits for the slowest
+// bucket it belongs to. A nil *Shaper lets everything through.
+type Shaper struct {
+	global    *tokenBucket
+	listeners map[string]*tokenBucket
+
+	clientRate int64
+	clientsMu  sync.Mutex
+	clients    map[string]*clientBucket
+	lastPrune  time.Time
+
+	rules []shapeRule
+	quota *dailyQuota
+
+	now func() time.Time
+}
+
+// clientBucket counts the connections sharing a client's bucket so it is
+// only dropped once none is left.
+type clientBucket struct {
+	bucket *tokenBucket
+	conns  int
+	last   time.Time
+}
+
+type shapeRule struct {
+	name   string
+	match  destMatch
+	bucket *tokenBucket
+}
+
+func NewShaper(cfg *config.Config) (*Shaper, error) {
+	if cfg.ShapeGlobal <= 0 && len(cfg.ShapeListeners) == 0 && cfg.ShapeClient <= 0 &&
+		len(cfg.ShapeRules) == 0 && cfg.QuotaDaily <= 0 {
+		return nil, nil
+	}
+
+	s := &Shaper{
+		listeners:  make(map[string]*tokenBucket),
+		clientRate: cfg.ShapeClient,
+		clients:    make(map[string]*clientBucket),
+		now:        time.Now,
+	}
+	if cfg.ShapeGlobal > 0 {
+		s.global = newTokenBucket(cfg.ShapeGlobal)
+	}
+	for name, rate := range cfg.ShapeListeners {
+		switch name {
+		case ListenerSOCKS, ListenerHTTP, ListenerTransparent, ListenerForward:
+		default:
+			return nil, fmt.Errorf("shaper: unknown listener %q", name)
+		}
+		s.listeners[name] = newTokenBucket(rate)
+	}
+	for _, r := range cfg.ShapeRules {
+		match, err := newDestMatch(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("shaper: %w", err)
+		}
+		s.rules = append(s.rules, shapeRule{name: r.Match, match: match, bucket: newTokenBucket(r.Rate)})
+	}
+	if cfg.QuotaDaily > 0 {
+		s.quota = &dailyQuota{limit: cfg.QuotaDaily}
+	}
+	return s, nil
+}
+
+// Admit refuses a new connection from a client that used up its quota.
+func (s *Shaper) Admit(ctx context.Context) error {
+	if s == nil || s.quota == nil {
+		return nil
+	}
+	client := ConnInfoFrom(ctx).Client
+	if !s.quota.allowed(client) {
+		metrics.ObserveQuotaReject(client)
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Wrap puts c under the buckets that apply to the client in ctx and to dst.
+func (s *Shaper) Wrap(ctx context.Context, c net.Conn, dst string) net.Conn {
+	if s == nil {
+		return c
+	}
+	ci := ConnInfoFrom(ctx)
+	sc := &shapedConn{Conn: c, client: ci.Client}
+
+	if s.global != nil {
+		sc.add("global", s.global)
+	}
+	if b := s.listeners[ci.Listener]; b != nil {
+		sc.add("listener:"+ci.Listener, b)
+	}
+	if b := s.acquireClient(ci.Client); b != nil {
+		sc.add("client:"+ci.Client, b)
+		sc.release = func() { s.releaseClient(ci.Client) }
+	}
+	if r := s.matchRule(dst); r != nil {
+		sc.add("rule:"+r.name, r.bucket)
+	}
+	if s.quota != nil && ci.Client != "" {
+		sc.quota = s.quota
+	}
+
+	if len(sc.buckets) == 0 && sc.quota == nil {
+		return c
+	}
+	return sc
+}
+
+func (s *Shaper) acquireClient(client string) *tokenBucket {
+	if s.clientRate <= 0 || client == "" {
+		return nil
+	}
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastPrune) > clientBucketIdle {
+		s.lastPrune = now
+		for k, cb := range s.clients {
+			if cb.conns == 0 && now.Sub(cb.last) > clientBucketIdle {
+				delete(s.clients, k)
+			}
+		}
+	}
+
+	cb, ok := s.clients[client]
+	if !ok {
+		cb = &clientBucket{bucket: newTokenBucket(s.clientRate)}
+		s.clients[client] = cb
+	}
+	cb.conns++
+	return cb.bucket
+}
+
+func (s *Shaper) releaseClient(client string) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	if cb, ok := s.clients[client]; ok {
+		cb.conns--
+		cb.last = s.now()
+	}
+}
+
+func (s *Shaper) matchRule(dst string) *shapeRule {
+	host, port, ok := splitDest(dst)
+	if !ok {
+		return nil
+	}
+	for i := range s.rules {
+		if s.rules[i].match(host, port) {
+			return &s.rules[i]
+		}
+	}
+	return nil
+}
+
+type shapedConn struct {
+	net.Conn
+	buckets []*tokenBucket
+	scopes  []string
+
+	quota  *dailyQuota
+	client string
+
+	release     func()
+	releaseOnce sync.Once
+}
+
+func (c *shapedConn) add(scope string, b *tokenBucket) {
+	c.buckets = append(c.buckets, b)
+	c.scopes = append(c.scopes, scope)
+}
+
+func (c *shapedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		if qErr := c.charge(n); qErr != nil && err == nil {
+			err = qErr
+		}
+	}
+	return n, err
+}
+
+func (c *shapedConn) Write(p []byte) (int, error) {
+	if err := c.charge(len(p)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *shapedConn) CloseWrite() error { return metrics.CloseWrite(c.Conn) }
+
+func (c *shapedConn) Close() error {
+	if c.release != nil {
+		c.releaseOnce.Do(c.release)
+	}
+	return c.Conn.Close()
+}
+
+// charge takes n bytes from every bucket and sleeps for the longest debt;
+// the buckets refill in parallel, so the waits do not add up.
+func (c *shapedConn) charge(n int) error {
+	var (
+		wait  time.Duration
+		scope string
+	)
+	for i, b := range c.buckets {
+		if d := b.take(n); d > wait {
+			wait, scope = d, c.scopes[i]
+		}
+	}
+	if wait > 0 {
+		metrics.ObserveThrottle(scope, wait)
+		time.Sleep(wait)
+	}
+
+	if c.quota != nil && !c.quota.add(c.client, n) {
+		metrics.ObserveQuotaReject(c.client)
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// dailyQuota counts bytes per client IP for the current local day.
+type dailyQuota struct {
+	limit int64
+
+	mu   sync.Mutex
+	day  string
+	used map[string]int64
+}
+
+func (q *dailyQuota) roll() {
+	if today := time.Now().Format(time.DateOnly); today != q.day {
+		q.day = today
+		q.used = make(map[string]int64)
+	}
+}
+
+func (q *dailyQuota) allowed(client string) bool {
+	if client == "" {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll()
+	return q.used[client] < q.limit
+}
+
+func (q *dailyQuota) add(client string, n int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll()
+	q.used[client] += int64(n)
+	return q.used[client] <= q.limit
+}
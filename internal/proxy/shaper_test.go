@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/config"
+)
+
+// wrapFrom wraps one end of a fresh pipe as a connection from client.
+func wrapFrom(t *testing.T, s *Shaper, client string) net.Conn {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() { _ = b.Close() })
+	ctx := WithConnInfo(context.Background(), ListenerSOCKS, net.JoinHostPort(client, "5000"))
+	return s.Wrap(ctx, a, "example.test:443")
+}
+
+func clientBucketOf(c net.Conn) *tokenBucket {
+	return c.(*shapedConn).buckets[0]
+}
+
+// A client's bucket lives while it has connections and is dropped once it
+// has been idle for clientBucketIdle, so the map does not grow with every
+// client ever seen.
+func TestClientBucketsExpire(t *testing.T) {
+	s, err := NewShaper(&config.Config{ShapeClient: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	a1 := wrapFrom(t, s, "192.0.2.1")
+	b1 := wrapFrom(t, s, "192.0.2.2")
+	_ = b1.Close()
+
+	now = now.Add(2 * clientBucketIdle)
+	c1 := wrapFrom(t, s, "192.0.2.3")
+	if n := len(s.clients); n != 2 {
+		t.Fatalf("%d client buckets, want 2: the idle one dropped, the busy one kept", n)
+	}
+	a2 := wrapFrom(t, s, "192.0.2.1")
+	if clientBucketOf(a2) != clientBucketOf(a1) {
+		t.Fatal("a client with an open connection lost its bucket")
+	}
+
+	_ = a1.Close()
+	_ = a2.Close()
+	_ = c1.Close()
+	now = now.Add(2 * clientBucketIdle)
+	_ = wrapFrom(t, s, "192.0.2.2")
+	if n := len(s.clients); n != 1 {
+		t.Fatalf("%d client buckets after everyone went idle, want 1", n)
+	}
+}
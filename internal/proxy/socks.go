@@ -17,27 +17,32 @@ type SocksServer struct {
 	listen string
 	srv    *socks5.Server
 	ln     net.Listener
+	dns    *DNSResolver
 }
 
-func NewSOCKS(cfg *config.Config, dial sshclient.DialFunc) (*SocksServer, error) {
+// NewSOCKS serves client connections through dial. Name lookups go through
+// resolveDial instead: they are the proxy's own traffic, not the client's,
+// and stay out of the access log and the shaper.
+func NewSOCKS(cfg *config.Config, dial, resolveDial sshclient.DialFunc, hosts Hosts) (*SocksServer, error) {
 
-	dnsR := NewDNSResolver(cfg.DNSServers, cfg.DNSv6, dial)
+	dnsR := NewDNSResolver(cfg.DNSServers, cfg.DNSv6, resolveDial).WithSplit(cfg.DNSSplit, hosts, cfg.DNSRemote)
 
 	srv, e := socks5.New(&socks5.Config{
 		Dial:     dial,
 		Resolver: dnsR,
+		Rules:    socksConnInfo{},
 		Logger:   log.New(io.Discard, "", 0),
 	})
 	if e != nil {
 		return nil, e
 	}
 
-	ln, e := net.Listen("tcp", cfg.SocksL)
+	ln, e := listenTCP(cfg.SocksL)
 	if e != nil {
 		return nil, e
 	}
 
-	ss := &SocksServer{cfg.SocksL, srv, ln}
+	ss := &SocksServer{cfg.SocksL, srv, ln, dnsR}
 	go func() {
 		zap.L().Info("SOCKS proxy listening on", zap.String("listen", cfg.SocksL))
 		if err := srv.Serve(ln); err != nil {
@@ -47,6 +52,10 @@ func NewSOCKS(cfg *config.Config, dial sshclient.DialFunc) (*SocksServer, error)
 	return ss, nil
 }
 
+func (s *SocksServer) FlushDNS() {
+	s.dns.Flush()
+}
+
 func (s *SocksServer) Shutdown(_ context.Context) error {
 	return s.ln.Close()
 }
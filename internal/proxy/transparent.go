@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/metrics"
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/sshclient"
+)
+
+const (
+	TransparentRedirect = "redirect"
+	TransparentTProxy   = "tproxy"
+
+	sniffTimeout = 300 * time.Millisecond
+	sniffMaxSize = 16 << 10
+)
+
+type TransparentServer struct {
+	ln    net.Listener
+	mode  string
+	sniff bool
+	dial  sshclient.DialFunc
+}
+
+func NewTransparent(listen, mode string, sniff bool, dial sshclient.DialFunc) (*TransparentServer, error) {
+	if mode != TransparentRedirect && mode != TransparentTProxy {
+		return nil, errors.New("transparent: unknown mode " + mode)
+	}
+
+	ln, err := listenTransparent(listen, mode == TransparentTProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &TransparentServer{ln: ln, mode: mode, sniff: sniff, dial: dial}
+	go func() {
+		zap.L().Info("transparent proxy listening on", zap.String("listen", listen), zap.String("mode", mode))
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				zap.L().Warn("transparent_accept_err", zap.Error(err))
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			go s.handle(c)
+		}
+	}()
+	return s, nil
+}
+
+func (s *TransparentServer) Shutdown(_ context.Context) error {
+	return s.ln.Close()
+}
+
+func (s *TransparentServer) handle(c net.Conn) {
+	var (
+		dst *net.TCPAddr
+		err error
+	)
+	if s.mode == TransparentTProxy {
+		dst, _ = c.LocalAddr().(*net.TCPAddr)
+	} else {
+		dst, err = originalDst(c)
+	}
+	if err != nil || dst == nil {
+		zap.L().Warn("transparent_no_original_dst", zap.String("client", c.RemoteAddr().String()), zap.Error(err))
+		_ = c.Close()
+		return
+	}
+
+	if s.mode == TransparentRedirect && dst.String() == c.LocalAddr().String() {
+		zap.L().Warn("transparent_loop", zap.String("client", c.RemoteAddr().String()))
+		_ = c.Close()
+		return
+	}
+
+	target := dst.String()
+	src := c
+	var host string
+	if s.sniff {
+		src, host = sniffHost(c)
+		if host != "" {
+			target = net.JoinHostPort(host, strconv.Itoa(dst.Port))
+		}
+	}
+
+	ctx := WithConnInfoOf(context.Background(), ConnInfo{Listener: ListenerTransparent, ClientAddr: c.RemoteAddr().String(), Host: host})
+	up, err := s.dial(ctx, "tcp", target)
+	if err != nil {
+		zap.L().Debug("transparent_dial_err", zap.String("target", target), zap.Error(err))
+		_ = src.Close()
+		return
+	}
+	copyBoth(up, src)
+}
+
+type peekConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *peekConn) CloseWrite() error { return metrics.CloseWrite(c.Conn) }
+
+// sniffHost peeks at the first client bytes for a TLS SNI or an HTTP Host
+// header. Server-first protocols simply hit the deadline and yield no name.
+func sniffHost(c net.Conn) (net.Conn, string) {
+	pc := &peekConn{Conn: c, r: bufio.NewReaderSize(c, sniffMaxSize)}
+
+	_ = c.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer func() { _ = c.SetReadDeadline(time.Time{}) }()
+
+	head, err := pc.r.Peek(5)
+	if err != nil {
+		return pc, ""
+	}
+
+	if head[0] == 0x16 {
+		n := int(binary.BigEndian.Uint16(head[3:5]))
+		if 5+n > sniffMaxSize {
+			return pc, ""
+		}
+		rec, err := pc.r.Peek(5 + n)
+		if err != nil {
+			return pc, ""
+		}
+		return pc, parseSNI(rec[5:])
+	}
+
+	// look at whatever has arrived after every read, so a short request is
+	// not held until the deadline waiting for bytes that never come
+	for {
+		buf, _ := pc.r.Peek(pc.r.Buffered())
+		if i := bytes.Index(buf, []byte("\r\n\r\n")); i >= 0 {
+			req, e := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:i+4])))
+			if e != nil {
+				return pc, ""
+			}
+			host := req.Host
+			if h, _, e := net.SplitHostPort(host); e == nil {
+				host = h
+			}
+			return pc, host
+		}
+		if len(buf) >= sniffMaxSize {
+			return pc, ""
+		}
+		if _, err := pc.r.Peek(len(buf) + 1); err != nil {
+			return pc, ""
+		}
+	}
+}
+
+func parseSNI(b []byte) string {
+	// handshake header: type(1) len(3), then version(2) random(32)
+	if len(b) < 38 || b[0] != 0x01 {
+		return ""
+	}
+	b = b[38:]
+
+	skip := func(lenBytes int) bool {
+		if len(b) < lenBytes {
+			return false
+		}
+		n := 0
+		for i := 0; i < lenBytes; i++ {
+			n = n<<8 | int(b[i])
+		}
+		if len(b) < lenBytes+n {
+			return false
+		}
+		b = b[lenBytes+n:]
+		return true
+	}
+
+	// session id, cipher suites, compression methods
+	if !skip(1) || !skip(2) || !skip(1) || len(b) < 2 {
+		return ""
+	}
+	b = b[2:]
+
+	for len(b) >= 4 {
+		typ := binary.BigEndian.Uint16(b[0:2])
+		n := int(binary.BigEndian.Uint16(b[2:4]))
+		b = b[4:]
+		if len(b) < n {
+			return ""
+		}
+		ext := b[:n]
+		b = b[n:]
+		if typ != 0 || len(ext) < 2 {
+			continue
+		}
+		ext = ext[2:]
+		for len(ext) >= 3 {
+			nameType := ext[0]
+			l := int(binary.BigEndian.Uint16(ext[1:3]))
+			ext = ext[3:]
+			if len(ext) < l {
+				return ""
+			}
+			if nameType == 0 {
+				return strings.TrimSuffix(string(ext[:l]), ".")
+			}
+			ext = ext[l:]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+//go:build linux
+
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/handoff"
+)
+
+func listenTransparent(listen string, tproxy bool) (net.Listener, error) {
+	lc := listenConfig()
+	if tproxy {
+		lc.Control = func(network, _ string, rc syscall.RawConn) error {
+			var opErr error
+			err := rc.Control(func(fd uintptr) {
+				if network == "tcp6" {
+					opErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+					return
+				}
+				opErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return opErr
+		}
+	}
+	// an inherited socket already carries IP_TRANSPARENT
+	return handoff.Listen(lc, "tcp", listen)
+}
+
+// originalDst recovers the pre-NAT destination of a REDIRECTed connection.
+func originalDst(c net.Conn) (*net.TCPAddr, error) {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil, errors.New("transparent: not a TCP connection")
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	v6 := false
+	if la, ok := c.LocalAddr().(*net.TCPAddr); ok && la.IP.To4() == nil {
+		v6 = true
+	}
+
+	var (
+		addr  *net.TCPAddr
+		opErr error
+	)
+	err = rc.Control(func(fd uintptr) {
+		if v6 {
+			info, e := unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, unix.SO_ORIGINAL_DST)
+			if e != nil {
+				opErr = e
+				return
+			}
+			port := make([]byte, 2)
+			binary.NativeEndian.PutUint16(port, info.Addr.Port)
+			addr = &net.TCPAddr{
+				IP:   append(net.IP(nil), info.Addr.Addr[:]...),
+				Port: int(binary.BigEndian.Uint16(port)),
+			}
+			return
+		}
+		mreq, e := unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, unix.SO_ORIGINAL_DST)
+		if e != nil {
+			opErr = e
+			return
+		}
+		// raw sockaddr_in: family(2) port(2, network order) addr(4)
+		addr = &net.TCPAddr{
+			IP:   net.IPv4(mreq.Multiaddr[4], mreq.Multiaddr[5], mreq.Multiaddr[6], mreq.Multiaddr[7]),
+			Port: int(mreq.Multiaddr[2])<<8 | int(mreq.Multiaddr[3]),
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return addr, opErr
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"errors"
+	"net"
+)
+
+var errTransparentUnsupported = errors.New("transparent proxy is supported on Linux only")
+
+func listenTransparent(string, bool) (net.Listener, error) {
+	return nil, errTransparentUnsupported
+}
+
+func originalDst(net.Conn) (*net.TCPAddr, error) {
+	return nil, errTransparentUnsupported
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// A complete request shorter than any fixed peek size is sniffed as soon as
+// its header ends, not when the sniff deadline runs out.
+func TestSniffShortHTTPRequest(t *testing.T) {
+	ln := listenLoopback(t)
+	client, proxied := tcpPair(t, ln)
+	defer client.Close()
+	defer proxied.Close()
+
+	req := "GET / HTTP/1.1\r\nHost: example.test:8080\r\n\r\n"
+	if _, err := io.WriteString(client, req); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	pc, host := sniffHost(proxied)
+	if took := time.Since(start); took >= sniffTimeout {
+		t.Fatalf("sniff took %v, want it done before the %v deadline", took, sniffTimeout)
+	}
+	if host != "example.test" {
+		t.Fatalf("host = %q, want %q", host, "example.test")
+	}
+
+	got := make([]byte, len(req))
+	if _, err := io.ReadFull(pc, got); err != nil || string(got) != req {
+		t.Fatalf("replayed %q, %v; want the request unchanged", got, err)
+	}
+}
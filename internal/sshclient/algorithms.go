@@ -0,0 +1,77 @@
+package sshclient
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+const clientVersionPrefix = "SSH-2.0-"
+
+// AlgorithmConfig holds hardening knobs for the SSH transport. Empty lists
+// keep the x/crypto defaults. Compression is not implemented by x/crypto/ssh.
+type AlgorithmConfig struct {
+	Ciphers        []string
+	KeyExchanges   []string
+	MACs           []string
+	HostKeys       []string
+	RekeyThreshold uint64
+	ClientVersion  string
+}
+
+func (a AlgorithmConfig) apply(cfg *ssh.ClientConfig) error {
+	supported, insecure := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+
+	checks := []struct {
+		kind  string
+		want  []string
+		known []string
+	}{
+		{"cipher", a.Ciphers, append(supported.Ciphers, insecure.Ciphers...)},
+		{"kex", a.KeyExchanges, append(supported.KeyExchanges, insecure.KeyExchanges...)},
+		{"mac", a.MACs, append(supported.MACs, insecure.MACs...)},
+		{"host key", a.HostKeys, append(supported.HostKeys, insecure.HostKeys...)},
+	}
+	for _, c := range checks {
+		for _, name := range c.want {
+			if !slices.Contains(c.known, name) {
+				return fmt.Errorf("ssh: unsupported %s algorithm %q", c.kind, name)
+			}
+		}
+	}
+
+	cfg.Ciphers = a.Ciphers
+	cfg.KeyExchanges = a.KeyExchanges
+	cfg.MACs = a.MACs
+	cfg.HostKeyAlgorithms = a.HostKeys
+	cfg.RekeyThreshold = a.RekeyThreshold
+
+	if v := a.ClientVersion; v != "" {
+		if !strings.HasPrefix(v, clientVersionPrefix) {
+			v = clientVersionPrefix + v
+		}
+		cfg.ClientVersion = v
+	}
+	return nil
+}
+
+func logNegotiated(linkID int, cl *ssh.Client) {
+	am, ok := cl.Conn.(ssh.AlgorithmsConnMetadata)
+	if !ok {
+		return
+	}
+	alg := am.Algorithms()
+	zap.L().Info("ssh_algorithms",
+		zap.Int("link", linkID),
+		zap.String("server_version", string(cl.ServerVersion())),
+		zap.String("kex", alg.KeyExchange),
+		zap.String("host_key", alg.HostKey),
+		zap.String("cipher_out", alg.Write.Cipher),
+		zap.String("cipher_in", alg.Read.Cipher),
+		zap.String("mac_out", alg.Write.MAC),
+		zap.String("mac_in", alg.Read.MAC),
+	)
+}
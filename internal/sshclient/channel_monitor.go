@@ -1,6 +1,7 @@
 package sshclient
 
 import (
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -14,6 +15,17 @@ func StartChannelMonitor(r *Reconnector) {
 		defer t.Stop()
 		for range t.C {
 			zap.L().Debug("ssh_channels", zap.Int64("current", r.Channels()))
+			if len(r.links) < 2 {
+				continue
+			}
+			for _, l := range r.links {
+				zap.L().Debug("ssh_link_channels",
+					zap.Int("link", l.id),
+					zap.Bool("up", l.current() != nil),
+					zap.Int64("current", l.totalChans()),
+					zap.Int64("max", atomic.LoadInt64(&l.maxChans)),
+				)
+			}
 		}
 	}()
 }
@@ -2,20 +2,75 @@ package sshclient
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
 	"os"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/config"
 )
 
 type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
 
-func New(user, pass, host, port, keyPath string) (*Reconnector, DialFunc, error) {
-	cfg := buildConfig(user, pass, keyPath)
-	addr := net.JoinHostPort(host, port)
+// ErrConfig marks errors that retrying cannot fix.
+var ErrConfig = errors.New("ssh: invalid configuration")
+
+// New connects to c.Server; resolve is consulted before every connect so the
+// server may move to another address between reconnects.
+func New(c *config.Config, resolve Resolver) (*Reconnector, DialFunc, error) {
+	cfg := buildConfig(c.Login, c.Password, c.KeyPath)
+	algs := AlgorithmConfig{
+		Ciphers:        c.SSHCiphers,
+		KeyExchanges:   c.SSHKex,
+		MACs:           c.SSHMACs,
+		HostKeys:       c.SSHHostKeyAlgos,
+		RekeyThreshold: c.SSHRekeyThreshold,
+		ClientVersion:  c.SSHClientVersion,
+	}
+	if err := algs.apply(cfg); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrConfig, err)
+	}
+	addr := net.JoinHostPort(c.Server, c.Port)
+
+	upDial, err := UpstreamProxy(c.UpstreamProxy, c.UpstreamProxyEnv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrConfig, err)
+	}
+
+	tr, err := NewTransport(TransportConfig{
+		Kind:        c.SSHTransport,
+		TLSSNI:      c.SSHTLSSNI,
+		TLSInsecure: c.SSHTLSInsecure,
+		WSURL:       c.SSHWSURL,
+		HTTPProxy:   c.SSHHTTPProxy,
+		ServerName:  c.Server,
+	}, upDial)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrConfig, err)
+	}
 
-	reConnector, err := NewReconnector(addr, cfg)
+	reConnector, err := NewReconnector(addr, cfg, Options{
+		Sessions: c.SSHSessions,
+		Policy: ReconnectPolicy{
+			Initial:     c.ReconnectInitial,
+			Max:         c.ReconnectMax,
+			Multiplier:  c.ReconnectMultiplier,
+			Jitter:      c.ReconnectJitter,
+			MaxAttempts: c.ReconnectMaxAttempts,
+		},
+		BreakerThreshold: c.BreakerThreshold,
+		ProbeChannels:    c.ProbeChannels,
+		ProbeTargets:     c.ProbeTargets,
+		MaxSessions:      c.MaxSessions,
+		HandoverGrace:    c.HandoverGrace,
+		Transport:        tr,
+		Lazy:             c.LazyConnect,
+		IdleTimeout:      c.IdleDisconnect,
+		Resolve:          resolve,
+	})
 	if err != nil {
 		return nil, nil, err
 	}
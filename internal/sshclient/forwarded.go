@@ -0,0 +1,59 @@
+package sshclient
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Listen asks the server behind cl to listen on addr. Accepted connections
+// count against cl's generation like dialed channels, so a handover waits
+// for them to drain instead of cutting them.
+func (r *Reconnector) Listen(cl *ssh.Client, network, addr string) (net.Listener, error) {
+	ln, err := cl.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	l, g := r.genOf(cl)
+	if g == nil {
+		return ln, nil
+	}
+	return &genListener{Listener: ln, gen: g, upstream: fmt.Sprintf("%s#%d", r.Addr(), l.id)}, nil
+}
+
+// genOf finds the generation, current or draining, that owns cl.
+func (r *Reconnector) genOf(cl *ssh.Client) (*link, *generation) {
+	for _, l := range r.links {
+		l.mu.RLock()
+		if l.gen != nil && l.gen.client == cl {
+			g := l.gen
+			l.mu.RUnlock()
+			return l, g
+		}
+		for g := range l.draining {
+			if g.client == cl {
+				l.mu.RUnlock()
+				return l, g
+			}
+		}
+		l.mu.RUnlock()
+	}
+	return nil, nil
+}
+
+type genListener struct {
+	net.Listener
+	gen      *generation
+	upstream string
+}
+
+func (ln *genListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&ln.gen.chans, 1)
+	return &channelConn{Conn: c, gen: ln.gen, upstream: ln.upstream}, nil
+}
@@ -0,0 +1,116 @@
+package sshclient
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+const drainPoll = 200 * time.Millisecond
+
+var genSeq uint64
+
+// generation is one SSH client of a link. Channels are counted against the
+// generation that opened them, so a handover never skews the numbers.
+type generation struct {
+	id     uint64
+	client *ssh.Client
+	chans  int64
+	// retired: no new channels, existing ones may still drain
+	retired int32
+	// lost: the transport itself is gone
+	lost int32
+}
+
+func newGeneration(cl *ssh.Client) *generation {
+	return &generation{id: atomic.AddUint64(&genSeq, 1), client: cl}
+}
+
+func (g *generation) usable() bool {
+	return atomic.LoadInt32(&g.retired) == 0 && atomic.LoadInt32(&g.lost) == 0
+}
+
+// detach takes the current generation out of rotation; new Dials wait for
+// its successor while its channels keep running.
+func (l *link) detach() *generation {
+	return l.swap(nil)
+}
+
+// swap makes g the current generation and moves its predecessor to the
+// draining set: new Dials go to g, the old channels keep running.
+func (l *link) swap(g *generation) *generation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	old := l.gen
+	l.gen = g
+	if old != nil {
+		atomic.StoreInt32(&old.retired, 1)
+		l.draining[old] = struct{}{}
+	}
+	return old
+}
+
+// markLost flags g as broken after a failed channel open, ahead of its
+// watch noticing the transport is gone.
+func (l *link) markLost(g *generation) {
+	if atomic.CompareAndSwapInt32(&g.lost, 0, 1) {
+		l.rec.refreshState()
+	}
+}
+
+// retire keeps the old client open until its channels drain or the grace
+// period expires. A lost transport has nothing left to drain.
+func (l *link) retire(old *generation) {
+	if old == nil {
+		return
+	}
+	grace := l.rec.opts.HandoverGrace
+
+	go func() {
+		deadline := time.Now().Add(grace)
+		for atomic.LoadInt64(&old.chans) > 0 &&
+			atomic.LoadInt32(&old.lost) == 0 &&
+			time.Now().Before(deadline) {
+			time.Sleep(drainPoll)
+		}
+
+		_ = old.client.Close()
+
+		l.mu.Lock()
+		delete(l.draining, old)
+		l.mu.Unlock()
+
+		zap.L().Info("ssh_generation_closed",
+			zap.Int("link", l.id),
+			zap.Uint64("gen", old.id),
+			zap.Int64("cut_channels", atomic.LoadInt64(&old.chans)),
+		)
+	}()
+}
+
+// totalChans counts channels of the current and all draining generations.
+func (l *link) totalChans() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var n int64
+	if l.gen != nil {
+		n += atomic.LoadInt64(&l.gen.chans)
+	}
+	for g := range l.draining {
+		n += atomic.LoadInt64(&g.chans)
+	}
+	return n
+}
+
+func (l *link) currentChans() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.gen == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&l.gen.chans)
+}
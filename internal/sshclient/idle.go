@@ -0,0 +1,66 @@
+package sshclient
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const minIdleCheck = 1 * time.Second
+
+func (r *Reconnector) isIdle() bool {
+	return atomic.LoadInt32(&r.idle) == 1
+}
+
+// wake leaves idle mode: the Dial that follows brings the primary back up,
+// the secondary links reconnect in the background.
+func (r *Reconnector) wake() {
+	atomic.StoreInt64(&r.lastActive, time.Now().UnixNano())
+	if !atomic.CompareAndSwapInt32(&r.idle, 1, 0) {
+		return
+	}
+	zap.L().Info("ssh_wake", zap.String("addr", r.Addr()))
+	for _, l := range r.links[1:] {
+		go l.reconnect()
+	}
+}
+
+// startIdleWatch closes every link once no channel has been open for
+// timeout. Remote listeners (OnConnect hooks) pin the session open since
+// nobody on this side would ever Dial to wake it again.
+func (r *Reconnector) startIdleWatch(timeout time.Duration) {
+	check := timeout / 4
+	if check < minIdleCheck {
+		check = minIdleCheck
+	}
+	atomic.StoreInt64(&r.lastActive, time.Now().UnixNano())
+
+	go func() {
+		t := time.NewTicker(check)
+		defer t.Stop()
+		for range t.C {
+			if r.isIdle() || r.Channels() > 0 || r.hasHooks() {
+				atomic.StoreInt64(&r.lastActive, time.Now().UnixNano())
+				continue
+			}
+			last := time.Unix(0, atomic.LoadInt64(&r.lastActive))
+			if time.Since(last) < timeout {
+				continue
+			}
+
+			atomic.StoreInt32(&r.idle, 1)
+			for _, l := range r.links {
+				l.retire(l.detach())
+			}
+			r.refreshState()
+			zap.L().Info("ssh_idle_disconnect", zap.String("addr", r.Addr()), zap.Duration("idle", time.Since(last)))
+		}
+	}()
+}
+
+func (r *Reconnector) hasHooks() bool {
+	r.hooksMu.Lock()
+	defer r.hooksMu.Unlock()
+	return len(r.hooks) > 0
+}
@@ -6,28 +6,43 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/config"
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/metrics"
 )
 
-func StartKeepAlive(cfg *config.Config, r *Reconnector, interval time.Duration) {
+func StartKeepAlive(cfg *config.Config, r *Reconnector) {
+	for _, l := range r.links {
+		l.startKeepAlive(cfg.KeepAliveInterval, cfg.KeepAliveTimeout, cfg.KeepAliveMaxMissed)
+	}
+}
+
+// startKeepAlive declares the peer dead only after maxMissed consecutive
+// failed or late replies, so a transient blip does not tear down channels.
+func (l *link) startKeepAlive(interval, timeout time.Duration, maxMissed int) {
+	if maxMissed < 1 {
+		maxMissed = 1
+	}
+
 	go func() {
 		t := time.NewTicker(interval)
 		defer t.Stop()
 
+		missed := 0
 		for range t.C {
-			r.mu.RLock()
-			cl := r.client
-			r.mu.RUnlock()
+			cl := l.current()
 
 			if cl == nil {
-				zap.L().Debug("keepalive: no client")
-				if err := r.reconnect(); err != nil {
-					zap.L().Warn("keepalive: reconnect error", zap.Error(err))
+				missed = 0
+				if l.rec.isIdle() {
+					continue
+				}
+				zap.L().Debug("keepalive: no client", zap.Int("link", l.id))
+				if err := l.reconnect(); err != nil {
+					zap.L().Warn("keepalive: reconnect error", zap.Int("link", l.id), zap.Error(err))
 				}
 				continue
 			}
 
-			const timeout = 10 * time.Second
-
+			start := time.Now()
 			done := make(chan error, 1)
 			go func() {
 				_, _, err := cl.SendRequest("keepalive@openssh.com", true, nil)
@@ -36,13 +51,27 @@ func StartKeepAlive(cfg *config.Config, r *Reconnector, interval time.Duration)
 
 			select {
 			case err := <-done:
-				if err != nil {
-					zap.L().Warn("keepalive: send error", zap.Error(err))
-					_ = r.reconnect()
+				if err == nil {
+					missed = 0
+					metrics.ObserveRTT(l.id, time.Since(start))
+					continue
 				}
+				missed++
+				zap.L().Warn("keepalive: send error", zap.Int("link", l.id), zap.Int("missed", missed), zap.Error(err))
 			case <-time.After(timeout):
-				zap.L().Warn("keepalive: timeout", zap.Duration("after", timeout))
-				_ = r.reconnect()
+				missed++
+				zap.L().Warn("keepalive: timeout", zap.Int("link", l.id), zap.Int("missed", missed), zap.Duration("after", timeout))
+			}
+
+			if missed >= maxMissed {
+				zap.L().Warn("keepalive: peer dead", zap.Int("link", l.id), zap.Int("missed", missed))
+				missed = 0
+				// out of rotation now: Dials wait or fail fast instead of
+				// piling onto the dead transport while the reconnect runs
+				if g := l.currentGen(); g != nil && g.client == cl {
+					l.markLost(g)
+				}
+				_ = l.reconnect()
 			}
 		}
 	}()
@@ -1,33 +1,30 @@
 package sshclient
 
 import (
-	"time"
+	"sync/atomic"
 
 	"go.uber.org/zap"
 )
 
-func (r *Reconnector) startConnMonitor() {
-	go func() {
-		for {
-			r.mu.RLock()
-			cl := r.client
-			r.mu.RUnlock()
+// watch waits for the transport of one generation to end. Only the loss of
+// the current generation triggers a reconnect; retired ones just go away.
+func (l *link) watch(g *generation) {
+	if err := g.client.Wait(); err != nil {
+		zap.L().Warn("ssh_conn_lost", zap.Int("link", l.id), zap.Uint64("gen", g.id), zap.Error(err))
+	} else {
+		zap.L().Warn("ssh_conn_closed", zap.Int("link", l.id), zap.Uint64("gen", g.id))
+	}
+	atomic.StoreInt32(&g.lost, 1)
 
-			if cl == nil {
-				time.Sleep(200 * time.Millisecond)
-				continue
-			}
+	l.mu.RLock()
+	isCurrent := l.gen == g
+	l.mu.RUnlock()
+	if !isCurrent {
+		return
+	}
 
-			if err := cl.Wait(); err != nil {
-				zap.L().Warn("ssh_conn_lost", zap.Error(err))
-			} else {
-				zap.L().Warn("ssh_conn_closed")
-			}
-
-			if err := r.reconnect(); err != nil {
-				zap.L().Warn("ssh_reconnect_failed", zap.Error(err))
-			}
-
-		}
-	}()
+	l.rec.refreshState()
+	if err := l.reconnect(); err != nil {
+		zap.L().Warn("ssh_reconnect_failed", zap.Int("link", l.id), zap.Error(err))
+	}
 }
@@ -0,0 +1,63 @@
+package sshclient
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	netSettle          = 2 * time.Second
+	clockCheckInterval = 5 * time.Second
+	clockJumpThreshold = 10 * time.Second
+)
+
+// StartNetWatch calls fn once the local network changed under us: a route or
+// address update (Linux netlink) or a wall-clock jump left by a suspend.
+// Bursts of events within netSettle are folded into a single call.
+func StartNetWatch(fn func(reason string)) {
+	events := make(chan string, 1)
+	notify := func(reason string) {
+		select {
+		case events <- reason:
+		default:
+		}
+	}
+
+	if err := watchRoutes(notify); err != nil {
+		zap.L().Warn("netwatch: routes unavailable", zap.Error(err))
+	}
+	go watchClock(notify)
+
+	go func() {
+		for reason := range events {
+			time.Sleep(netSettle)
+			select {
+			case <-events:
+			default:
+			}
+			zap.L().Info("network_changed", zap.String("reason", reason))
+			fn(reason)
+		}
+	}()
+}
+
+// watchClock compares wall and monotonic time: the monotonic clock stops
+// while the machine sleeps, the wall clock does not.
+func watchClock(notify func(string)) {
+	t := time.NewTicker(clockCheckInterval)
+	defer t.Stop()
+
+	prev := time.Now()
+	for range t.C {
+		now := time.Now()
+		wall := now.Round(0).Sub(prev.Round(0))
+		mono := now.Sub(prev)
+		prev = now
+
+		if wall-mono > clockJumpThreshold || mono-wall > clockJumpThreshold {
+			zap.L().Debug("netwatch: clock jump", zap.Duration("wall", wall), zap.Duration("mono", mono))
+			notify("clock_jump")
+		}
+	}
+}
@@ -0,0 +1,78 @@
+//go:build linux
+
+package sshclient
+
+import (
+	"syscall"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// watchRoutes listens for address changes and default-route updates. Other
+// route churn (docker bridges, our own TUN routes) is ignored.
+func watchRoutes(notify func(string)) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	sa := &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR |
+			unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE,
+	}
+	if err = unix.Bind(fd, sa); err != nil {
+		_ = unix.Close(fd)
+		return err
+	}
+
+	go func() {
+		defer unix.Close(fd)
+		buf := make([]byte, 1<<16)
+		for {
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if err == unix.EINTR || err == unix.ENOBUFS {
+					continue
+				}
+				zap.L().Warn("netwatch: netlink read", zap.Error(err))
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				if reason := routeEvent(m); reason != "" {
+					notify(reason)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func routeEvent(m syscall.NetlinkMessage) string {
+	switch m.Header.Type {
+	case unix.RTM_NEWADDR, unix.RTM_DELADDR:
+		if len(m.Data) < unix.SizeofIfAddrmsg {
+			return ""
+		}
+		// ifa_scope: link-local and host addresses do not move traffic
+		if m.Data[3] >= unix.RT_SCOPE_LINK {
+			return ""
+		}
+		return "address"
+
+	case unix.RTM_NEWROUTE, unix.RTM_DELROUTE:
+		if len(m.Data) < unix.SizeofRtMsg {
+			return ""
+		}
+		// rtm_dst_len == 0 is a default route; rtm_table filters local/cache
+		if m.Data[1] != 0 || m.Data[4] != unix.RT_TABLE_MAIN {
+			return ""
+		}
+		return "default_route"
+	}
+	return ""
+}
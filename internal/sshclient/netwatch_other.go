@@ -0,0 +1,5 @@
+//go:build !linux
+
+package sshclient
+
+func watchRoutes(func(string)) error { return nil }
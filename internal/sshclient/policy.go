@@ -0,0 +1,38 @@
+package sshclient
+
+import (
+	"math/rand"
+	"time"
+)
+
+// ReconnectPolicy shapes the reconnect backoff; its defaults come from the
+// RECONNECT_* settings in config.
+type ReconnectPolicy struct {
+	Initial     time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	Jitter      float64
+	MaxAttempts int // 0 means retry forever
+}
+
+func (p ReconnectPolicy) unlimited() bool { return p.MaxAttempts <= 0 }
+
+// next grows the base delay up to Max; the returned sleep is the base
+// spread by ±Jitter so a fleet of clients does not reconnect in lockstep.
+func (p ReconnectPolicy) next(base time.Duration) (sleep, nextBase time.Duration) {
+	sleep = base
+	if p.Jitter > 0 {
+		spread := (rand.Float64()*2 - 1) * p.Jitter * float64(base)
+		sleep = base + time.Duration(spread)
+	}
+
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+	nextBase = time.Duration(float64(base) * mult)
+	if p.Max > 0 && nextBase > p.Max {
+		nextBase = p.Max
+	}
+	return sleep, nextBase
+}
@@ -3,13 +3,14 @@ package sshclient
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 	"golang.org/x/crypto/ssh"
 )
 
-func probeMaxChannels(cl *ssh.Client) int64 {
+func probeMaxChannels(cl *ssh.Client, targets []string) int64 {
 	const (
 		safetyCap      = 512
 		workers        = safetyCap / 4
@@ -17,9 +18,8 @@ func probeMaxChannels(cl *ssh.Client) int64 {
 		safeMaxDefault = 64
 	)
 
-	targets := []string{
-		"1.1.1.1:443",
-		"8.8.8.8:443",
+	if len(targets) == 0 {
+		return safeMaxDefault
 	}
 
 	type result struct {
@@ -83,9 +83,44 @@ func probeMaxChannels(cl *ssh.Client) int64 {
 		}
 	}
 
-	if okCnt == 0 || okCnt < safeMaxDefault {
+	if okCnt == 0 {
 		zap.L().Warn("probe_max_channels_failed – using safe default", zap.Int("default", safeMaxDefault))
 		return safeMaxDefault
 	}
+	// a small server limit is still the limit; raising it would only earn
+	// ResourceShortage refusals
 	return okCnt
 }
+
+// channelLimit picks the per-transport channel cap: a static override wins,
+// then an active probe if enabled; 0 means unlimited until the server
+// answers ResourceShortage and learnLimit lowers it.
+func (r *Reconnector) channelLimit(cl *ssh.Client) int64 {
+	if r.opts.MaxSessions > 0 {
+		return r.opts.MaxSessions
+	}
+	if r.opts.ProbeChannels {
+		return probeMaxChannels(cl, r.opts.ProbeTargets)
+	}
+	return 0
+}
+
+func (l *link) learnLimit() {
+	if l.rec.opts.MaxSessions > 0 {
+		return
+	}
+	cur := l.currentChans()
+	if cur < 1 {
+		cur = 1
+	}
+	for {
+		old := atomic.LoadInt64(&l.maxChans)
+		if old != 0 && old <= cur {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&l.maxChans, old, cur) {
+			zap.L().Info("ssh_max_channels_learned", zap.Int("link", l.id), zap.Int64("max_channels", cur))
+			return
+		}
+	}
+}
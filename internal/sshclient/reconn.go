@@ -3,6 +3,7 @@ package sshclient
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"sync"
@@ -11,6 +12,8 @@ import (
 
 	"go.uber.org/zap"
 	"golang.org/x/crypto/ssh"
+
+	"github.com/GoSeoTaxi/cli-ssh2proxy/internal/metrics"
 )
 
 const (
@@ -21,144 +24,452 @@ const (
 	sshConnTimeout          = 5 * time.Second
 )
 
+// Reconnector stripes channels over one or more parallel SSH transports to
+// the same server. Link 0 is the primary: server-side state registered via
+// OnConnect lives there.
 type Reconnector struct {
-	addr string
-	cfg  *ssh.ClientConfig
+	addrMu   sync.RWMutex
+	addr     string
+	lastGood string
+	cfg      *ssh.ClientConfig
+	opts     Options
+
+	links []*link
+
+	stateTracker
+
+	waitMu sync.Mutex
+	waitCh chan struct{}
+
+	idle       int32
+	lastActive int64
+
+	hooksMu sync.Mutex
+	hooks   []func(cl *ssh.Client)
+}
+
+// link is one SSH transport with its own channel accounting, keepalive and
+// reconnect loop.
+type link struct {
+	id  int
+	rec *Reconnector
 
 	mu       sync.RWMutex
-	client   *ssh.Client
-	chanCnt  int64
+	gen      *generation
+	draining map[*generation]struct{}
 	maxChans int64
 
 	reconFlag int32
 }
 
-func NewReconnector(addr string, cfg *ssh.ClientConfig) (*Reconnector, error) {
-	cl, err := ssh.Dial("tcp", addr, cfg)
+type Options struct {
+	Sessions         int
+	Policy           ReconnectPolicy
+	BreakerThreshold int
+
+	ProbeChannels bool
+	ProbeTargets  []string
+	MaxSessions   int64
+
+	HandoverGrace time.Duration
+
+	Transport Transport
+
+	// Lazy defers the first connect to the first Dial; IdleTimeout > 0
+	// closes the session after that long without channels.
+	Lazy        bool
+	IdleTimeout time.Duration
+
+	// Resolve looks up the server host before every connect; nil dials
+	// the address as given.
+	Resolve Resolver
+}
+
+func NewReconnector(addr string, cfg *ssh.ClientConfig, opts Options) (*Reconnector, error) {
+	sessions := opts.Sessions
+	if sessions < 1 {
+		sessions = 1
+	}
+
+	if opts.Transport == nil {
+		opts.Transport, _ = NewTransport(TransportConfig{}, nil)
+	}
+
+	r := &Reconnector{addr: addr, cfg: cfg, opts: opts}
+	r.breakerThreshold = int64(opts.BreakerThreshold)
+	for i := 0; i < sessions; i++ {
+		r.links = append(r.links, &link{id: i, rec: r, draining: make(map[*generation]struct{})})
+	}
+
+	if opts.IdleTimeout > 0 {
+		r.startIdleWatch(opts.IdleTimeout)
+	}
+
+	if opts.Lazy {
+		r.idle = 1
+		r.refreshState()
+		zap.L().Info("ssh_lazy", zap.String("addr", addr))
+		return r, nil
+	}
+
+	primary := r.links[0]
+	cl, err := primary.connect()
 	if err != nil {
 		zap.L().Warn("ssh_up_err", zap.String("addr", addr), zap.Error(err))
 		return nil, err
 	}
+	primary.gen = newGeneration(cl)
+	primary.maxChans = r.channelLimit(cl)
+	go primary.watch(primary.gen)
+	r.refreshState()
+
+	zap.L().Info("ssh_up", zap.String("addr", addr), zap.Int("link", 0), zap.Int64("max_channels", primary.maxChans))
+
+	for _, l := range r.links[1:] {
+		l := l
+		go func() {
+			if err := l.reconnect(); err != nil {
+				zap.L().Warn("ssh_link_up_err", zap.Int("link", l.id), zap.Error(err))
+			}
+		}()
+	}
+	return r, nil
+}
 
-	r := &Reconnector{addr: addr, cfg: cfg, client: cl}
-	r.startConnMonitor()
+// Dial waits for a reconnect up to the ctx deadline, or fails fast with
+// ErrCircuitOpen while the upstream is known down; both wrap ErrUpstreamDown.
+func (r *Reconnector) Dial(ctx context.Context, n, a string) (net.Conn, error) {
+	r.wake()
+	return r.pick().dial(ctx, n, a)
+}
 
-	r.maxChans = probeMaxChannels(cl)
+// Addr is the address the last successful connect used, or the configured
+// host:port before the first one.
+func (r *Reconnector) Addr() string {
+	r.addrMu.RLock()
+	defer r.addrMu.RUnlock()
+	if r.lastGood != "" {
+		return r.lastGood
+	}
+	return r.addr
+}
 
-	zap.L().Info("ssh_up", zap.String("addr", addr), zap.Int64("max_channels", r.maxChans))
-	return r, nil
+// Kick reconnects every link right away instead of waiting for keepalives
+// to notice that the network under the old transports went away.
+func (r *Reconnector) Kick() {
+	if r.isIdle() {
+		return
+	}
+	for _, l := range r.links {
+		go l.reconnect()
+	}
 }
 
-func (r *Reconnector) Dial(ctx context.Context, n, a string) (net.Conn, error) {
-	if err := r.waitForSlot(ctx); err != nil {
-		return nil, err
+func (r *Reconnector) refreshState() {
+	up := 0
+	for _, l := range r.links {
+		if l.current() != nil {
+			up++
+		}
 	}
+	r.update(up, len(r.links), r.isIdle())
+	r.broadcast()
+}
 
-	for i := 0; i < countAttemptsDial; i++ {
-		r.mu.RLock()
-		cl := r.client
-		r.mu.RUnlock()
+// changed returns a channel closed on the next link or breaker transition.
+func (r *Reconnector) changed() <-chan struct{} {
+	r.waitMu.Lock()
+	defer r.waitMu.Unlock()
+	if r.waitCh == nil {
+		r.waitCh = make(chan struct{})
+	}
+	return r.waitCh
+}
 
-		if cl == nil {
-			if err := r.reconnect(); err != nil {
-				return nil, err
-			}
+func (r *Reconnector) broadcast() {
+	r.waitMu.Lock()
+	if r.waitCh != nil {
+		close(r.waitCh)
+		r.waitCh = nil
+	}
+	r.waitMu.Unlock()
+}
+
+func (r *Reconnector) anyUp() bool {
+	for _, l := range r.links {
+		if l.current() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// pick returns the connected link with the lowest channel load; when every
+// link is down the primary takes the call and drives the reconnect.
+func (r *Reconnector) pick() *link {
+	var (
+		best     *link
+		bestLoad float64
+	)
+	for _, l := range r.links {
+		if l.current() == nil {
 			continue
 		}
+		load := l.load()
+		if best == nil || load < bestLoad {
+			best, bestLoad = l, load
+		}
+	}
+	if best == nil {
+		return r.links[0]
+	}
+	return best
+}
+
+// OnConnect registers fn to run with every new primary SSH client, including
+// the current one, so server-side state (remote listeners) survives reconnects.
+// A lazy session comes up right away: nothing local would Dial to wake it.
+func (r *Reconnector) OnConnect(fn func(cl *ssh.Client)) {
+	r.hooksMu.Lock()
+	r.hooks = append(r.hooks, fn)
+	r.hooksMu.Unlock()
+
+	if cl := r.links[0].current(); cl != nil {
+		go fn(cl)
+		return
+	}
+	if r.isIdle() {
+		r.wake()
+		go r.links[0].reconnect()
+	}
+}
+
+func (r *Reconnector) fireHooks(cl *ssh.Client) {
+	r.hooksMu.Lock()
+	hooks := append([]func(*ssh.Client){}, r.hooks...)
+	r.hooksMu.Unlock()
+
+	for _, fn := range hooks {
+		go fn(cl)
+	}
+}
+
+func (r *Reconnector) Close() {
+	for _, l := range r.links {
+		l.close()
+	}
+	r.refreshState()
+}
+
+func (r *Reconnector) Channels() int64 {
+	var n int64
+	for _, l := range r.links {
+		n += l.totalChans()
+	}
+	return n
+}
 
-		conn, err := cl.Dial(n, a)
+func (l *link) current() *ssh.Client {
+	if g := l.currentGen(); g != nil {
+		return g.client
+	}
+	return nil
+}
+
+func (l *link) currentGen() *generation {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.gen == nil || !l.gen.usable() {
+		return nil
+	}
+	return l.gen
+}
+
+func (l *link) load() float64 {
+	cnt := float64(l.currentChans())
+	if maxCh := atomic.LoadInt64(&l.maxChans); maxCh > 0 {
+		return cnt / float64(maxCh)
+	}
+	return cnt
+}
+
+func (l *link) dial(ctx context.Context, n, a string) (net.Conn, error) {
+	if err := l.waitForSlot(ctx); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < countAttemptsDial; i++ {
+		g, err := l.waitUp(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := g.client.DialContext(ctx, n, a)
 		if err == nil {
-			atomic.AddInt64(&r.chanCnt, 1)
-			return &channelConn{Conn: conn, rec: r}, nil
+			atomic.AddInt64(&g.chans, 1)
+			return &channelConn{Conn: conn, gen: g, upstream: fmt.Sprintf("%s#%d", l.rec.Addr(), l.id)}, nil
 		}
 
 		if ocErr, ok := err.(*ssh.OpenChannelError); ok {
 			zap.L().Warn("ssh_channel_open_failed",
+				zap.Int("link", l.id),
 				zap.Uint32("reason_code", uint32(ocErr.Reason)),
 				zap.String("reason_text", ocErr.Message),
 				zap.String("upstream_addr", a),
 			)
+			if ocErr.Reason == ssh.ResourceShortage {
+				l.learnLimit()
+			}
 			return nil, err
 		}
 
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUpstreamDown, ctx.Err())
+		}
 		if isNetErr(err) {
-			if r.reconnect() == nil {
-				continue
-			}
-			return nil, err
+			// out of rotation now, so waitUp blocks until the successor is up
+			l.markLost(g)
+			go l.reconnect()
+			continue
 		}
 
 		return nil, err
 	}
-	return nil, errors.New("ssh: reconnect failed")
+	return nil, fmt.Errorf("%w: reconnect failed", ErrUpstreamDown)
 }
 
-func (r *Reconnector) Close() {
-	r.mu.Lock()
-	if r.client != nil {
-		_ = r.client.Close()
-		r.client = nil
-		zap.L().Info("ssh_down", zap.String("addr", r.addr))
+// waitUp returns the live generation, or blocks until a reconnect brings one
+// up. It gives up at the caller's deadline, or at once when the breaker is open.
+func (l *link) waitUp(ctx context.Context) (*generation, error) {
+	r := l.rec
+	for {
+		changed := r.changed()
+		if g := l.currentGen(); g != nil {
+			return g, nil
+		}
+		if r.breakerOpen() {
+			return nil, ErrCircuitOpen
+		}
+
+		go l.reconnect()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %v", ErrUpstreamDown, ctx.Err())
+		}
 	}
-	r.mu.Unlock()
 }
 
-func (r *Reconnector) reconnect() error {
-	if !atomic.CompareAndSwapInt32(&r.reconFlag, 0, 1) {
-		for atomic.LoadInt32(&r.reconFlag) == 1 {
-			time.Sleep(10 * time.Millisecond)
-		}
-		return nil
+func (l *link) close() {
+	l.mu.Lock()
+	if l.gen != nil {
+		_ = l.gen.client.Close()
+		l.gen = nil
+		zap.L().Info("ssh_down", zap.String("addr", l.rec.Addr()), zap.Int("link", l.id))
+	}
+	for g := range l.draining {
+		_ = g.client.Close()
+		delete(l.draining, g)
 	}
-	defer atomic.StoreInt32(&r.reconFlag, 0)
+	l.mu.Unlock()
+}
 
-	r.mu.Lock()
-	if r.client != nil {
-		_ = r.client.Close()
-		r.client = nil
-		zap.L().Info("ssh_down", zap.String("addr", r.addr))
+// reconnect is single-flight: a concurrent call returns immediately and the
+// caller waits for the outcome through waitUp. The current generation keeps
+// taking Dials until its successor is up; if every attempt fails it stays
+// unless its transport is gone.
+func (l *link) reconnect() error {
+	if !atomic.CompareAndSwapInt32(&l.reconFlag, 0, 1) {
+		return nil
 	}
-	r.mu.Unlock()
+	defer atomic.StoreInt32(&l.reconFlag, 0)
+	defer l.rec.broadcast()
 
-	backoff := timeOutBackoff
-	for attempt := 0; attempt < countAttemptsDial; attempt++ {
-		d := net.Dialer{Timeout: sshConnTimeout}
-		raw, err := d.Dial("tcp", r.addr)
+	r := l.rec
 
+	pol := r.opts.Policy
+	base := pol.Initial
+	if base <= 0 {
+		base = timeOutBackoff
+	}
+	for attempt := 0; pol.unlimited() || attempt < pol.MaxAttempts; attempt++ {
+		cl, err := l.connect()
 		if err != nil {
-			zap.L().Warn("ssh_reconnect_err", zap.Int("attempt", attempt+1), zap.Duration("backoff", backoff), zap.Error(err))
+			var sleep time.Duration
+			sleep, base = pol.next(base)
+			zap.L().Warn("ssh_reconnect_err", zap.Int("link", l.id), zap.Int("attempt", attempt+1), zap.Duration("backoff", sleep), zap.Error(err))
+			r.attemptFailed(err, r.anyUp())
+			r.broadcast()
 
-			time.Sleep(backoff)
-			backoff *= 2
+			time.Sleep(sleep)
 			continue
 		}
 
-		cc, chans, reqs, err := ssh.NewClientConn(raw, r.addr, r.cfg)
-		if err != nil {
-			_ = raw.Close()
-			zap.L().Warn("ssh_reconnect_err", zap.Int("attempt", attempt+1), zap.Duration("backoff", backoff), zap.Error(err))
+		atomic.StoreInt64(&l.maxChans, r.channelLimit(cl))
 
-			time.Sleep(backoff)
-			backoff *= 2
-			continue
-		}
-		cl := ssh.NewClient(cc, chans, reqs)
-
-		r.mu.Lock()
-		r.client = cl
-		r.mu.Unlock()
+		g := newGeneration(cl)
+		old := l.swap(g)
+		go l.watch(g)
 
-		r.maxChans = probeMaxChannels(cl)
+		l.retire(old)
 
-		atomic.StoreInt64(&r.chanCnt, 0)
+		if l.id == 0 {
+			r.fireHooks(cl)
+		}
+		r.refreshState()
 
-		zap.L().Info("ssh_reconnect_ok", zap.Int("attempt", attempt+1), zap.Duration("backoff_used", backoff/2), zap.Int64("max_channels", r.maxChans))
+		zap.L().Info("ssh_reconnect_ok", zap.Int("link", l.id), zap.Uint64("gen", g.id), zap.Int("attempt", attempt+1), zap.Int64("max_channels", atomic.LoadInt64(&l.maxChans)))
 		return nil
 	}
-	zap.L().Error("ssh_reconnect_failed", zap.String("addr", r.addr), zap.Int("attempts", countAttemptsDial))
+	if l.currentGen() == nil {
+		l.retire(l.detach())
+	}
+	r.refreshState()
+	zap.L().Error("ssh_reconnect_failed", zap.String("addr", r.Addr()), zap.Int("link", l.id), zap.Int("attempts", pol.MaxAttempts))
 	return errors.New("ssh: retries exceeded")
 }
 
+// connect tries every address the server host currently resolves to and
+// remembers the one that worked.
+func (l *link) connect() (*ssh.Client, error) {
+	r := l.rec
+	addrs, err := r.candidates()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		var cl *ssh.Client
+		cl, err = l.connectAddr(addr)
+		if err == nil {
+			r.markGood(addr)
+			return cl, nil
+		}
+		zap.L().Debug("ssh_connect_addr_err", zap.Int("link", l.id), zap.String("addr", addr), zap.Error(err))
+	}
+	return nil, err
+}
+
+func (l *link) connectAddr(addr string) (*ssh.Client, error) {
+	r := l.rec
+	ctx, cancel := context.WithTimeout(context.Background(), sshConnTimeout)
+	raw, err := r.opts.Transport(ctx, addr)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	cc, chans, reqs, err := ssh.NewClientConn(raw, r.addr, r.cfg)
+	if err != nil {
+		_ = raw.Close()
+		return nil, err
+	}
+	cl := ssh.NewClient(cc, chans, reqs)
+	logNegotiated(l.id, cl)
+	return cl, nil
+}
+
 func isNetErr(err error) bool {
 	if err == io.EOF {
 		return true
@@ -169,37 +480,49 @@ func isNetErr(err error) bool {
 
 type channelConn struct {
 	net.Conn
-	rec    *Reconnector
-	closed uint32
+	gen      *generation
+	upstream string
+	closed   uint32
+}
+
+// Upstream names the SSH server address and link the channel runs over.
+func (c *channelConn) Upstream() string { return c.upstream }
+
+// CloseWrite sends EOF on the channel while keeping its read side open.
+func (c *channelConn) CloseWrite() error { return metrics.CloseWrite(c.Conn) }
+
+// UpstreamOf returns the SSH upstream of a conn from Dial, or "".
+func UpstreamOf(c net.Conn) string {
+	if u, ok := c.(interface{ Upstream() string }); ok {
+		return u.Upstream()
+	}
+	return ""
 }
 
 func (c *channelConn) Close() error {
 	if atomic.CompareAndSwapUint32(&c.closed, 0, 1) {
-		atomic.AddInt64(&c.rec.chanCnt, -1)
+		atomic.AddInt64(&c.gen.chans, -1)
 	}
 	return c.Conn.Close()
 }
 
-func (r *Reconnector) Channels() int64 {
-	return atomic.LoadInt64(&r.chanCnt)
-}
-
-func (r *Reconnector) waitForSlot(ctx context.Context) error {
-	if r.maxChans == 0 {
+func (l *link) waitForSlot(ctx context.Context) error {
+	if atomic.LoadInt64(&l.maxChans) == 0 {
 		return nil
 	}
 	deadline := time.NewTimer(slotTimeOutHardWaitSlot)
 	defer deadline.Stop()
 
 	for {
-		if atomic.LoadInt64(&r.chanCnt) < r.maxChans {
+		if l.currentChans() < atomic.LoadInt64(&l.maxChans) {
 			return nil
 		}
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return fmt.Errorf("%w: %v", ErrUpstreamDown, ctx.Err())
 		case <-deadline.C:
-			return errors.New("ssh: slot wait timeout")
+			// a saturated link is unavailable to the client like a down one
+			return fmt.Errorf("%w: slot wait timeout", ErrUpstreamDown)
 		case <-time.After(timeOutWaitSlot):
 		}
 	}
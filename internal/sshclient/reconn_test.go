@@ -0,0 +1,426 @@
+package sshclient
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startTestServer runs an SSH server that echoes direct-tcpip channels and
+// serves tcpip-forward requests on loopback.
+func startTestServer(t *testing.T) string {
+	t.Helper()
+	return startMutableTestServer(t, new(atomic.Bool))
+}
+
+// startMutableTestServer is startTestServer whose keepalive replies stop
+// while mute is set, like a peer that vanished without closing the socket.
+func startMutableTestServer(t *testing.T, mute *atomic.Bool) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestConn(c, cfg, mute)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveTestConn(c net.Conn, cfg *ssh.ServerConfig, mute *atomic.Bool) {
+	sc, chans, reqs, err := ssh.NewServerConn(c, cfg)
+	if err != nil {
+		_ = c.Close()
+		return
+	}
+	go serveTestForwards(sc, reqs, mute)
+
+	for nc := range chans {
+		if nc.ChannelType() != "direct-tcpip" {
+			_ = nc.Reject(ssh.UnknownChannelType, "")
+			continue
+		}
+		ch, creqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go ssh.DiscardRequests(creqs)
+		go func() {
+			_, _ = io.Copy(ch, ch)
+			_ = ch.CloseWrite()
+			_ = ch.Close()
+		}()
+	}
+}
+
+func serveTestForwards(sc *ssh.ServerConn, reqs <-chan *ssh.Request, mute *atomic.Bool) {
+	var (
+		mu        sync.Mutex
+		listeners = make(map[string]net.Listener)
+	)
+	defer func() {
+		mu.Lock()
+		for _, ln := range listeners {
+			_ = ln.Close()
+		}
+		mu.Unlock()
+	}()
+
+	for req := range reqs {
+		if req.Type == "keepalive@openssh.com" {
+			if !mute.Load() {
+				_ = req.Reply(true, nil)
+			}
+			continue
+		}
+
+		var fwd struct {
+			Addr string
+			Port uint32
+		}
+		if err := ssh.Unmarshal(req.Payload, &fwd); err != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		switch req.Type {
+		case "tcpip-forward":
+			ln, err := net.Listen("tcp", net.JoinHostPort(fwd.Addr, strconv.Itoa(int(fwd.Port))))
+			if err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			port := uint32(ln.Addr().(*net.TCPAddr).Port)
+			mu.Lock()
+			listeners[net.JoinHostPort(fwd.Addr, strconv.Itoa(int(port)))] = ln
+			mu.Unlock()
+			_ = req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+
+			go func(addr string) {
+				for {
+					c, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					payload := ssh.Marshal(struct {
+						Addr       string
+						Port       uint32
+						OriginAddr string
+						OriginPort uint32
+					}{addr, port, "127.0.0.1", 1})
+					ch, creqs, err := sc.OpenChannel("forwarded-tcpip", payload)
+					if err != nil {
+						_ = c.Close()
+						continue
+					}
+					go ssh.DiscardRequests(creqs)
+					go func() { _, _ = io.Copy(ch, c); _ = ch.CloseWrite() }()
+					go func() { _, _ = io.Copy(c, ch); _ = c.Close() }()
+				}
+			}(fwd.Addr)
+
+		case "cancel-tcpip-forward":
+			key := net.JoinHostPort(fwd.Addr, strconv.Itoa(int(fwd.Port)))
+			mu.Lock()
+			if ln, ok := listeners[key]; ok {
+				_ = ln.Close()
+				delete(listeners, key)
+			}
+			mu.Unlock()
+			_ = req.Reply(true, nil)
+
+		default:
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// gatedTransport lets the first connect through and holds every later one
+// until release is closed.
+func gatedTransport(release <-chan struct{}) Transport {
+	var n int32
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		if atomic.AddInt32(&n, 1) > 1 {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+}
+
+func newTestReconnector(t *testing.T, opts Options) *Reconnector {
+	t.Helper()
+	return newTestReconnectorAt(t, startTestServer(t), opts)
+}
+
+func newTestReconnectorAt(t *testing.T, addr string, opts Options) *Reconnector {
+	t.Helper()
+	cfg := &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	r, err := NewReconnector(addr, cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+func echo(t *testing.T, c net.Conn, msg string) {
+	t.Helper()
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Write([]byte(msg)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != msg {
+		t.Fatalf("echo = %q, want %q", buf, msg)
+	}
+}
+
+func genOfConn(c net.Conn) *generation { return c.(*channelConn).gen }
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// A handover keeps routing Dials to the old client until the new one is up,
+// and leaves channels of the old client running.
+func TestHandoverConnectsFirst(t *testing.T) {
+	release := make(chan struct{})
+	r := newTestReconnector(t, Options{Transport: gatedTransport(release), HandoverGrace: 10 * time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	before, err := r.Dial(ctx, "tcp", "127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer before.Close()
+	g0 := genOfConn(before)
+
+	r.Kick()
+	time.Sleep(100 * time.Millisecond)
+
+	during, err := r.Dial(ctx, "tcp", "127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("dial while the new client connects: %v", err)
+	}
+	defer during.Close()
+	if genOfConn(during) != g0 {
+		t.Fatal("dial during handover did not use the old client")
+	}
+
+	close(release)
+	waitFor(t, "new generation", func() bool {
+		g := r.links[0].currentGen()
+		return g != nil && g != g0
+	})
+
+	after, err := r.Dial(ctx, "tcp", "127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer after.Close()
+	if genOfConn(after) == g0 {
+		t.Fatal("dial after handover still used the old client")
+	}
+	echo(t, before, "old")
+	echo(t, after, "new")
+}
+
+// A dial that hits a dead transport waits for the reconnect instead of
+// burning its attempts on the broken client.
+func TestDialWaitsForReconnect(t *testing.T) {
+	release := make(chan struct{})
+	r := newTestReconnector(t, Options{Transport: gatedTransport(release)})
+
+	_ = r.links[0].current().Close()
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		close(release)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := r.Dial(ctx, "tcp", "127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	echo(t, c, "ping")
+}
+
+// Connections accepted on a remote listener hold the old client open across
+// a handover just like dialed channels.
+func TestHandoverKeepsAcceptedChannels(t *testing.T) {
+	r := newTestReconnector(t, Options{HandoverGrace: 10 * time.Second})
+	old := r.links[0].currentGen()
+
+	ln, err := r.Listen(old.client, "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	remote, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	var local net.Conn
+	select {
+	case local = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no forwarded connection")
+	}
+	defer local.Close()
+	if n := atomic.LoadInt64(&old.chans); n != 1 {
+		t.Fatalf("old generation counts %d channels, want 1", n)
+	}
+	_ = ln.Close()
+
+	r.Kick()
+	waitFor(t, "new generation", func() bool {
+		g := r.links[0].currentGen()
+		return g != nil && g != old
+	})
+	time.Sleep(3 * drainPoll)
+
+	_ = remote.SetDeadline(time.Now().Add(5 * time.Second))
+	_ = local.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := remote.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(local, buf); err != nil {
+		t.Fatalf("accepted tunnel cut by handover: %v", err)
+	}
+}
+
+// Remote listeners have no local Dial to wake a lazy session, so registering
+// one brings the session up.
+func TestLazyConnectsForHooks(t *testing.T) {
+	r := newTestReconnector(t, Options{Lazy: true})
+	if r.links[0].current() != nil {
+		t.Fatal("lazy session connected before anything needed it")
+	}
+
+	got := make(chan *ssh.Client, 1)
+	r.OnConnect(func(cl *ssh.Client) { got <- cl })
+	select {
+	case cl := <-got:
+		if cl == nil {
+			t.Fatal("hook ran with a nil client")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hook never ran on a lazy session")
+	}
+}
+
+// failingTransport lets the first connect through and refuses every later
+// one, like a server that went away for good.
+func failingTransport() Transport {
+	var n int32
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		if atomic.AddInt32(&n, 1) > 1 {
+			return nil, errors.New("connection refused")
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+}
+
+// A peer that stops answering keepalives is taken out of rotation at once:
+// the link reads as down, the breaker can open and Dials fail fast instead
+// of hanging on the dead transport while the reconnect loops.
+func TestKeepAliveDeadPeerFailsFast(t *testing.T) {
+	mute := new(atomic.Bool)
+	r := newTestReconnectorAt(t, startMutableTestServer(t, mute), Options{
+		Transport:        failingTransport(),
+		Policy:           ReconnectPolicy{Initial: 10 * time.Millisecond, Max: 20 * time.Millisecond},
+		BreakerThreshold: 1,
+	})
+	r.links[0].startKeepAlive(50*time.Millisecond, 50*time.Millisecond, 2)
+
+	mute.Store(true)
+	waitFor(t, "link down", func() bool { return !r.anyUp() })
+	waitFor(t, "breaker open", r.breakerOpen)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := r.Dial(ctx, "tcp", "127.0.0.1:1")
+	if !errors.Is(err, ErrUpstreamDown) {
+		t.Fatalf("dial err = %v, want ErrUpstreamDown", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("dial took %v, want a fast failure", took)
+	}
+}
+
+// A link at its channel limit answers like a down upstream, so SOCKS and
+// HTTP tell the client to retry rather than report a generic failure.
+func TestSaturatedLinkIsUpstreamDown(t *testing.T) {
+	r := newTestReconnector(t, Options{MaxSessions: 1})
+	ctx := context.Background()
+
+	c, err := r.Dial(ctx, "tcp", "127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err = r.Dial(ctx, "tcp", "127.0.0.1:1"); !errors.Is(err, ErrUpstreamDown) {
+		t.Fatalf("dial on a full link: err = %v, want ErrUpstreamDown", err)
+	}
+}
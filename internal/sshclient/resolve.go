@@ -0,0 +1,60 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"go.uber.org/zap"
+)
+
+// Resolver returns every address of host, in preference order.
+type Resolver func(ctx context.Context, host string) ([]net.IP, error)
+
+// candidates re-resolves the server host so a moved DNS record is picked up
+// on the next reconnect. The last address that worked goes first while it is
+// still published, and is used alone when resolution fails.
+func (r *Reconnector) candidates() ([]string, error) {
+	host, port, err := net.SplitHostPort(r.addr)
+	if err != nil || r.opts.Resolve == nil || net.ParseIP(host) != nil {
+		return []string{r.addr}, nil
+	}
+
+	r.addrMu.RLock()
+	last := r.lastGood
+	r.addrMu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sshConnTimeout)
+	ips, err := r.opts.Resolve(ctx, host)
+	cancel()
+	if err != nil || len(ips) == 0 {
+		if last == "" {
+			if err == nil {
+				err = errors.New("no addresses")
+			}
+			return nil, err
+		}
+		zap.L().Warn("ssh_resolve_err", zap.String("host", host), zap.String("fallback", last), zap.Error(err))
+		return []string{last}, nil
+	}
+
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		a := net.JoinHostPort(ip.String(), port)
+		if a == last {
+			addrs = append([]string{a}, addrs...)
+			continue
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs, nil
+}
+
+func (r *Reconnector) markGood(addr string) {
+	r.addrMu.Lock()
+	defer r.addrMu.Unlock()
+	if addr != r.lastGood {
+		zap.L().Info("ssh_addr", zap.String("host", r.addr), zap.String("addr", addr))
+		r.lastGood = addr
+	}
+}
@@ -0,0 +1,124 @@
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type State int32
+
+const (
+	StateUp State = iota
+	StateDegraded
+	StateDown
+	StateIdle
+)
+
+func (s State) String() string {
+	switch s {
+	case StateUp:
+		return "up"
+	case StateDegraded:
+		return "degraded"
+	case StateIdle:
+		return "idle"
+	default:
+		return "down"
+	}
+}
+
+type StateEvent struct {
+	From State
+	To   State
+	At   time.Time
+	Err  error
+}
+
+// ErrUpstreamDown carries "network is unreachable" on purpose: go-socks5
+// maps that text to reply 0x03, which is what clients should see.
+var ErrUpstreamDown = errors.New("ssh upstream down: network is unreachable")
+
+var ErrCircuitOpen = fmt.Errorf("%w: circuit open", ErrUpstreamDown)
+
+type stateTracker struct {
+	mu      sync.Mutex
+	state   State
+	subs    []func(StateEvent)
+	lastErr error
+
+	breakerThreshold int64
+	failures         int64
+	open             int32
+}
+
+func (t *stateTracker) OnStateChange(fn func(StateEvent)) {
+	t.mu.Lock()
+	t.subs = append(t.subs, fn)
+	t.mu.Unlock()
+}
+
+func (t *stateTracker) State() State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+func (t *stateTracker) breakerOpen() bool {
+	return atomic.LoadInt32(&t.open) == 1
+}
+
+// attemptFailed counts consecutive failures while no link is usable and
+// opens the breaker once the threshold is hit.
+func (t *stateTracker) attemptFailed(err error, anyUp bool) {
+	t.mu.Lock()
+	t.lastErr = err
+	t.mu.Unlock()
+
+	if anyUp || t.breakerThreshold <= 0 {
+		return
+	}
+	if atomic.AddInt64(&t.failures, 1) >= t.breakerThreshold &&
+		atomic.CompareAndSwapInt32(&t.open, 0, 1) {
+		zap.L().Warn("ssh_circuit_open", zap.Int64("failures", atomic.LoadInt64(&t.failures)))
+	}
+}
+
+func (t *stateTracker) update(up, total int, idle bool) {
+	next := StateDegraded
+	switch {
+	case up == total:
+		next = StateUp
+	case up == 0 && idle:
+		next = StateIdle
+	case up == 0:
+		next = StateDown
+	}
+
+	if up > 0 {
+		atomic.StoreInt64(&t.failures, 0)
+		if atomic.CompareAndSwapInt32(&t.open, 1, 0) {
+			zap.L().Info("ssh_circuit_closed")
+		}
+	}
+
+	t.mu.Lock()
+	if next == t.state {
+		t.mu.Unlock()
+		return
+	}
+	ev := StateEvent{From: t.state, To: next, At: time.Now(), Err: t.lastErr}
+	t.state = next
+	subs := append([]func(StateEvent){}, t.subs...)
+	t.mu.Unlock()
+
+	zap.L().Info("ssh_state", zap.String("from", ev.From.String()), zap.String("to", ev.To.String()),
+		zap.Int("links_up", up), zap.Int("links", total))
+	for _, fn := range subs {
+		fn(ev)
+	}
+}
@@ -0,0 +1,207 @@
+package sshclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	TransportTCP         = "tcp"
+	TransportTLS         = "tls"
+	TransportWebSocket   = "ws"
+	TransportHTTPConnect = "http-connect"
+)
+
+// Transport opens the byte stream the SSH handshake runs over.
+type Transport func(ctx context.Context, addr string) (net.Conn, error)
+
+type ContextDialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+type TransportConfig struct {
+	Kind        string
+	TLSSNI      string
+	TLSInsecure bool
+	WSURL       string
+	HTTPProxy   string
+
+	// ServerName is the configured server host. Transports get a resolved
+	// address, so it stands in for the TLS name when TLSSNI is empty.
+	ServerName string
+}
+
+func NewTransport(tc TransportConfig, base ContextDialer) (Transport, error) {
+	if base == nil {
+		base = directDial
+	}
+
+	switch tc.Kind {
+	case "", TransportTCP:
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			return base(ctx, "tcp", addr)
+		}, nil
+
+	case TransportTLS:
+		sni := tc.TLSSNI
+		if sni == "" {
+			sni = tc.ServerName
+		}
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialTLS(ctx, base, addr, sni, tc.TLSInsecure)
+		}, nil
+
+	case TransportWebSocket:
+		u, err := url.Parse(tc.WSURL)
+		if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+			return nil, fmt.Errorf("transport: invalid websocket url %q", tc.WSURL)
+		}
+		return func(ctx context.Context, _ string) (net.Conn, error) {
+			return dialWebSocket(ctx, base, u, tc.TLSSNI, tc.TLSInsecure)
+		}, nil
+
+	case TransportHTTPConnect:
+		u, err := url.Parse(tc.HTTPProxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("transport: invalid http proxy %q", tc.HTTPProxy)
+		}
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialHTTPConnect(ctx, base, u, addr)
+		}, nil
+	}
+	return nil, fmt.Errorf("transport: unknown kind %q", tc.Kind)
+}
+
+func directDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := net.Dialer{Timeout: sshConnTimeout}
+	return d.DialContext(ctx, network, addr)
+}
+
+func dialTLS(ctx context.Context, base ContextDialer, addr, sni string, insecure bool) (net.Conn, error) {
+	raw, err := base(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if sni == "" {
+		sni, _, _ = net.SplitHostPort(addr)
+	}
+
+	tc := tls.Client(raw, &tls.Config{ServerName: sni, InsecureSkipVerify: insecure})
+	if err = tc.HandshakeContext(ctx); err != nil {
+		_ = raw.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// dialWebSocket talks to a websocket-to-ssh bridge (websockify and friends):
+// every binary frame carries a chunk of the raw SSH stream.
+func dialWebSocket(ctx context.Context, base ContextDialer, u *url.URL, sni string, insecure bool) (net.Conn, error) {
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if u.Scheme == "wss" {
+		if sni == "" {
+			sni = u.Hostname()
+		}
+		conn, err = dialTLS(ctx, base, host, sni, insecure)
+	} else {
+		conn, err = base(ctx, "tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	origin := &url.URL{Scheme: "http", Host: u.Host}
+	if u.Scheme == "wss" {
+		origin.Scheme = "https"
+	}
+	wsCfg, err := websocket.NewConfig(u.String(), origin.String())
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+	ws, err := websocket.NewClient(wsCfg, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
+
+func dialHTTPConnect(ctx context.Context, base ContextDialer, proxy *url.URL, addr string) (net.Conn, error) {
+	conn, err := base(ctx, "tcp", proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		pass, _ := proxy.User.Password()
+		cred := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+cred)
+	}
+
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+	if err = req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	// the body of a 2xx CONNECT reply is the tunnel itself: never drain it
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("http connect via %s: %s", proxy.Host, strings.TrimSpace(resp.Status))
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
@@ -0,0 +1,67 @@
+package sshclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/proxy"
+)
+
+var proxyEnvVars = []string{"ALL_PROXY", "all_proxy", "HTTPS_PROXY", "https_proxy"}
+
+// UpstreamProxy returns the dialer that reaches the SSH server (and the
+// bootstrap DNS servers) through a socks5:// or http:// proxy. It returns
+// nil when no proxy is configured, meaning a direct dial.
+func UpstreamProxy(rawURL string, fromEnv bool) (ContextDialer, error) {
+	if rawURL == "" && fromEnv {
+		for _, k := range proxyEnvVars {
+			if v := os.Getenv(k); v != "" {
+				rawURL = v
+				break
+			}
+		}
+	}
+	if rawURL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("upstream proxy: %w", err)
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		d, err := proxy.FromURL(u, &net.Dialer{Timeout: sshConnTimeout})
+		if err != nil {
+			return nil, fmt.Errorf("upstream proxy: %w", err)
+		}
+		cd, ok := d.(proxy.ContextDialer)
+		if !ok {
+			return nil, fmt.Errorf("upstream proxy: %s dialer has no context support", u.Scheme)
+		}
+		return cd.DialContext, nil
+
+	case "http", "https":
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			u.Host = net.JoinHostPort(u.Hostname(), port)
+		}
+		base := directDial
+		if u.Scheme == "https" {
+			base = func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return dialTLS(ctx, directDial, addr, "", false)
+			}
+		}
+		return func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialHTTPConnect(ctx, base, u, addr)
+		}, nil
+	}
+	return nil, fmt.Errorf("upstream proxy: unsupported scheme %q", u.Scheme)
+}